@@ -0,0 +1,279 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// FlowTaskType The task type of flow runs enqueued by a BackgroundRunner.
+const FlowTaskType = "flow.run"
+
+// ErrExecutionNotFound Returned when looking up an execution which doesn't exist.
+var ErrExecutionNotFound = errors.New("execution not found")
+
+// ExecutionStore Keeps the executions of flows run in the background.
+type ExecutionStore interface {
+	// SaveExecution Creates or replaces an execution.
+	SaveExecution(exec *Execution) error
+
+	// GetExecution Returns an execution, or ErrExecutionNotFound.
+	GetExecution(id string) (*Execution, error)
+}
+
+// MemoryExecutions ExecutionStore which keeps executions in memory. It is the
+// default store of a BackgroundRunner.
+type MemoryExecutions struct {
+	mutex      sync.Mutex
+	executions map[string]Execution
+}
+
+// NewMemoryExecutions Creates an empty in-memory execution store.
+func NewMemoryExecutions() *MemoryExecutions {
+	return &MemoryExecutions{
+		executions: make(map[string]Execution),
+	}
+}
+
+// SaveExecution Implements ExecutionStore.
+func (s *MemoryExecutions) SaveExecution(exec *Execution) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.executions[exec.ID] = *exec
+	return nil
+}
+
+// GetExecution Implements ExecutionStore.
+func (s *MemoryExecutions) GetExecution(id string) (*Execution, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	exec, ok := s.executions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrExecutionNotFound, id)
+	}
+	return &exec, nil
+}
+
+// SaveExecution Implements ExecutionStore.
+func (s *FileStore) SaveExecution(exec *Execution) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(s.path("_executions", exec.ID+".json"), exec)
+}
+
+// GetExecution Implements ExecutionStore.
+func (s *FileStore) GetExecution(id string) (*Execution, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, err := os.ReadFile(s.path("_executions", id+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: '%s'", ErrExecutionNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	exec := &Execution{}
+	return exec, json.Unmarshal(b, exec)
+}
+
+// flowRun Payload of a flow run task.
+type flowRun struct {
+	Execution string `json:"execution"`
+	Namespace string `json:"namespace"`
+	Flow      string `json:"flow"`
+	Version   int    `json:"version"`
+	Data      []byte `json:"data"`
+}
+
+type backgroundKey struct{}
+
+// BackgroundRunner Runs flows as tasks on a queue, so runs are retried on
+// failure and, with a persistent queue, survive a restart. Each run is recorded
+// as an Execution whose ID is also the ID of its task.
+type BackgroundRunner struct {
+	// Store Keeps the executions. Defaults to a MemoryExecutions store.
+	Store ExecutionStore
+
+	// Retries The maximum number of attempts of each run. Defaults to 3.
+	Retries int
+
+	// Configure Optionally adjusts each task before it is enqueued, e.g. to set
+	// its backoff or priority.
+	Configure func(task *Task)
+
+	// OnComplete Is called once a run completes or finally fails, with the
+	// final execution.
+	OnComplete func(exec *Execution, err error)
+
+	queue    *Queue
+	registry *Registry
+}
+
+// NewBackgroundRunner Creates a runner for the flows of a registry, and
+// registers the FlowTaskType task type on the queue. The registry defaults to
+// DefaultRegistry.
+func NewBackgroundRunner(queue *Queue, registry *Registry) *BackgroundRunner {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	r := &BackgroundRunner{
+		Store:    NewMemoryExecutions(),
+		Retries:  3,
+		queue:    queue,
+		registry: registry,
+	}
+	queue.RegisterTaskType(FlowTaskType, r.run)
+	queue.AfterTaskType(FlowTaskType, r.after)
+	return r
+}
+
+// Start Enqueues a run of the latest active version of a flow and returns its
+// pending execution. The RequestID of the input defaults to the execution ID.
+func (r *BackgroundRunner) Start(namespace, key string, data Data) (*Execution, error) {
+	return r.start(namespace, key, 0, data)
+}
+
+func (r *BackgroundRunner) start(namespace, key string, version int, data Data) (*Execution, error) {
+	exec, err := r.registry.newExecution(namespace, key, version, data)
+	if err != nil {
+		return nil, err
+	}
+	if exec.Input.RequestID == "" {
+		exec.Input.RequestID = exec.ID
+	}
+	input, err := exec.Input.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(flowRun{
+		Execution: exec.ID,
+		Namespace: exec.Namespace,
+		Flow:      exec.Flow,
+		Version:   exec.Version,
+		Data:      input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Store.SaveExecution(exec); err != nil {
+		return nil, err
+	}
+	task := NewTypedTask(FlowTaskType, payload).WithID(exec.ID).Retries(r.Retries)
+	task.Metadata["flow"] = exec.Flow
+	if r.Configure != nil {
+		r.Configure(task)
+	}
+	if err := r.queue.Enqueue(task); err != nil {
+		exec.Status = StatusFailed
+		exec.Error = err.Error()
+		_ = r.Store.SaveExecution(exec)
+		return nil, err
+	}
+	return exec, nil
+}
+
+// Get Returns an execution started by this runner.
+func (r *BackgroundRunner) Get(id string) (*Execution, error) {
+	return r.Store.GetExecution(id)
+}
+
+// run Attempts a flow run. A failed attempt leaves the execution pending until
+// the task is retried or finally fails.
+func (r *BackgroundRunner) run(ctx context.Context, payload []byte) error {
+	var run flowRun
+	if err := json.Unmarshal(payload, &run); err != nil {
+		return err
+	}
+	var data Data
+	if err := data.UnmarshalBinary(run.Data); err != nil {
+		return err
+	}
+	exec, err := r.Store.GetExecution(run.Execution)
+	if errors.Is(err, ErrExecutionNotFound) {
+		// Executions kept in memory are lost on restart, unlike the task
+		exec = &Execution{
+			ID:        run.Execution,
+			Namespace: run.Namespace,
+			Flow:      run.Flow,
+			Version:   run.Version,
+		}
+	} else if err != nil {
+		return err
+	}
+	exec.Input = data
+	err = r.registry.Run(context.WithValue(ctx, backgroundKey{}, true), exec)
+	if err != nil {
+		exec.Status = StatusPending
+	}
+	if err := r.Store.SaveExecution(exec); err != nil {
+		log.Printf("Failed to save execution %s: %v", exec.ID, err)
+	}
+	return err
+}
+
+// after Records the final outcome of a flow run and reports it.
+func (r *BackgroundRunner) after(ctx context.Context, task *Task) {
+	exec, err := r.Store.GetExecution(task.ID())
+	if err != nil {
+		log.Printf("Failed to load execution %s: %v", task.ID(), err)
+		return
+	}
+	err = finalError(task)
+	if err != nil {
+		exec.Status = StatusFailed
+		exec.Error = err.Error()
+		if exec.FinishedAt.IsZero() {
+			exec.FinishedAt = Now()
+		}
+	}
+	if err := r.Store.SaveExecution(exec); err != nil {
+		log.Printf("Failed to save execution %s: %v", exec.ID, err)
+	}
+	if r.OnComplete != nil {
+		r.OnComplete(exec, err)
+	}
+}
+
+// finalError Returns the result of a completed task, including the last error
+// of a task which exhausted its retries.
+func finalError(task *Task) error {
+	err := task.Result()
+	if errors.Is(err, ErrMaxRetriesExceeded) {
+		if last := task.LastError(); last != nil {
+			err = fmt.Errorf("%w: %v", ErrMaxRetriesExceeded, last)
+		}
+	}
+	return err
+}
+
+// WithRunner Sets the runner used when the flow's RawFlow sets
+// RunInBackground. Process then enqueues a run of this flow's version and
+// returns immediately with the input, its Status set to StatusPending and its
+// RequestID defaulting to the execution ID. The flow must have been built into
+// the runner's registry.
+func (f *Flow) WithRunner(r *BackgroundRunner) *Flow {
+	f.runner = r
+	return f
+}
+
+// background Enqueues a run of the flow unless it already runs in the
+// background, and reports whether it did.
+func (f *Flow) background(ctx context.Context, data Data) (Data, bool, error) {
+	if f.runner == nil || !f.RunInBackground() || ctx.Value(backgroundKey{}) != nil {
+		return data, false, nil
+	}
+	if f.Key == "" {
+		return data, true, ErrFlowKeyRequired
+	}
+	exec, err := f.runner.start(f.Namespace, f.Key, f.Version, data)
+	if err != nil {
+		return data, true, err
+	}
+	data = exec.Input
+	data.Status = StatusPending
+	return data, true, nil
+}
@@ -0,0 +1,53 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackgroundRunner(t *testing.T) {
+	registry := NewRegistry()
+	var calls int32
+	f := NewRaw(&RawFlow{Key: "report", RunInBackground: true}).WithRegistry(registry)
+	f.AddNode("render", func(ctx context.Context, d Data) (Data, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return d, errors.New("renderer busy")
+		}
+		d.Payload = Payload(`"rendered"`)
+		return d, nil
+	}).AddNode("store", echo).Edge("render", "store")
+	if f.Build().Error != nil {
+		t.Fatal(f.Error)
+	}
+
+	q := NewQueue("flows")
+	q.Now(func() time.Time {
+		return time.Now().UTC().Add(time.Hour)
+	})
+	runner := NewBackgroundRunner(q, registry)
+	var completed *Execution
+	runner.OnComplete = func(exec *Execution, err error) {
+		completed = exec
+	}
+	f.WithRunner(runner)
+
+	data, err := f.Process(context.Background(), Data{Payload: Payload(`"draft"`)})
+	if err != nil || data.Status != StatusPending || data.RequestID == "" {
+		t.Fatalf("expected a pending run, got %+v (%v)", data, err)
+	}
+	if exec, err := runner.Get(data.RequestID); err != nil || exec.Status != StatusPending || exec.Version != 1 {
+		t.Fatalf("expected a pending execution pinned to version 1, got %+v (%v)", exec, err)
+	}
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	if completed == nil || completed.ID != data.RequestID {
+		t.Fatalf("expected the completion callback for %s, got %+v", data.RequestID, completed)
+	}
+	if completed.Status != StatusCompleted || completed.Attempts != 2 || string(completed.Result.Payload) != `"rendered"` {
+		t.Fatalf("expected a completed execution after a retry, got %+v", completed)
+	}
+}
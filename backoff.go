@@ -0,0 +1,200 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff Computes the delay before re-attempting a failed task.
+type Backoff interface {
+	// Next Returns the delay after the given attempt (starting at 1) failed.
+	// previous is the delay returned for the attempt before, or zero.
+	Next(attempt int, previous time.Duration) time.Duration
+}
+
+var random = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// randomIntn Returns a random int in [0, n).
+func randomIntn(n int) int {
+	random.Lock()
+	defer random.Unlock()
+	return random.Intn(n)
+}
+
+// randomDuration Returns a random duration in [min, max].
+func randomDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	random.Lock()
+	defer random.Unlock()
+	return min + time.Duration(random.Int63n(int64(max-min)+1))
+}
+
+func capped(d, cap time.Duration) time.Duration {
+	if cap > 0 && d > cap {
+		return cap
+	}
+	return d
+}
+
+// exponential Returns base * multiplier^(attempt-1), saturating instead of
+// overflowing.
+func exponential(base time.Duration, multiplier float64, attempt int) time.Duration {
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	d := float64(base) * math.Pow(multiplier, float64(attempt-1))
+	if d >= math.MaxInt64 || math.IsInf(d, 0) || math.IsNaN(d) {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
+
+// ConstantBackoff Waits the same interval after every failure.
+type ConstantBackoff struct {
+	Interval time.Duration
+}
+
+// Next Implements Backoff.
+func (b ConstantBackoff) Next(attempt int, previous time.Duration) time.Duration {
+	return b.Interval
+}
+
+// LinearBackoff Waits Base after the first failure and Step longer after every
+// further one, up to Cap if it is set.
+type LinearBackoff struct {
+	Base time.Duration
+	Step time.Duration
+	Cap  time.Duration
+}
+
+// Next Implements Backoff.
+func (b LinearBackoff) Next(attempt int, previous time.Duration) time.Duration {
+	return capped(b.Base+time.Duration(attempt-1)*b.Step, b.Cap)
+}
+
+// ExponentialBackoff Waits Base after the first failure and multiplies the
+// delay by Multiplier (2 if unset) after every further one, up to Cap if it is
+// set.
+type ExponentialBackoff struct {
+	Base       time.Duration
+	Multiplier float64
+	Cap        time.Duration
+}
+
+// Next Implements Backoff.
+func (b ExponentialBackoff) Next(attempt int, previous time.Duration) time.Duration {
+	return capped(exponential(b.Base, b.Multiplier, attempt), b.Cap)
+}
+
+// FullJitterBackoff Waits a random delay between zero and the exponential
+// delay of ExponentialBackoff with the same settings.
+type FullJitterBackoff struct {
+	Base       time.Duration
+	Multiplier float64
+	Cap        time.Duration
+}
+
+// Next Implements Backoff.
+func (b FullJitterBackoff) Next(attempt int, previous time.Duration) time.Duration {
+	return randomDuration(0, capped(exponential(b.Base, b.Multiplier, attempt), b.Cap))
+}
+
+// DecorrelatedJitterBackoff Waits a random delay between Base and three times
+// the previous delay, up to Cap if it is set.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Cap  time.Duration
+}
+
+// Next Implements Backoff.
+func (b DecorrelatedJitterBackoff) Next(attempt int, previous time.Duration) time.Duration {
+	if previous < b.Base {
+		previous = b.Base
+	}
+	max := previous * 3
+	if max < previous {
+		max = math.MaxInt64
+	}
+	return capped(randomDuration(b.Base, max), b.Cap)
+}
+
+// ErrBackoffNotPersistable Returned when persisting a task with a custom
+// Backoff strategy, which a QueueStore can't serialize.
+var ErrBackoffNotPersistable = errors.New("backoff strategy can't be persisted")
+
+// BackoffRecord Serializable description of one of the Backoff strategies of
+// this package, as kept in a TaskRecord. Kind is "constant", "linear",
+// "exponential", "full_jitter" or "decorrelated_jitter".
+type BackoffRecord struct {
+	Kind       string        `json:"kind"`
+	Interval   time.Duration `json:"interval,omitempty"`
+	Base       time.Duration `json:"base,omitempty"`
+	Step       time.Duration `json:"step,omitempty"`
+	Multiplier float64       `json:"multiplier,omitempty"`
+	Cap        time.Duration `json:"cap,omitempty"`
+}
+
+// backoffRecord Describes a strategy, or returns nil if it isn't one of the
+// strategies of this package or a pointer to one. Pointers are reloaded as
+// values.
+func backoffRecord(b Backoff) *BackoffRecord {
+	switch b := b.(type) {
+	case ConstantBackoff:
+		return &BackoffRecord{Kind: "constant", Interval: b.Interval}
+	case LinearBackoff:
+		return &BackoffRecord{Kind: "linear", Base: b.Base, Step: b.Step, Cap: b.Cap}
+	case ExponentialBackoff:
+		return &BackoffRecord{Kind: "exponential", Base: b.Base, Multiplier: b.Multiplier, Cap: b.Cap}
+	case FullJitterBackoff:
+		return &BackoffRecord{Kind: "full_jitter", Base: b.Base, Multiplier: b.Multiplier, Cap: b.Cap}
+	case DecorrelatedJitterBackoff:
+		return &BackoffRecord{Kind: "decorrelated_jitter", Base: b.Base, Cap: b.Cap}
+	case *ConstantBackoff:
+		if b != nil {
+			return backoffRecord(*b)
+		}
+	case *LinearBackoff:
+		if b != nil {
+			return backoffRecord(*b)
+		}
+	case *ExponentialBackoff:
+		if b != nil {
+			return backoffRecord(*b)
+		}
+	case *FullJitterBackoff:
+		if b != nil {
+			return backoffRecord(*b)
+		}
+	case *DecorrelatedJitterBackoff:
+		if b != nil {
+			return backoffRecord(*b)
+		}
+	}
+	return nil
+}
+
+// Strategy Returns the Backoff described by the record.
+func (r *BackoffRecord) Strategy() (Backoff, error) {
+	switch r.Kind {
+	case "constant":
+		return ConstantBackoff{Interval: r.Interval}, nil
+	case "linear":
+		return LinearBackoff{Base: r.Base, Step: r.Step, Cap: r.Cap}, nil
+	case "exponential":
+		return ExponentialBackoff{Base: r.Base, Multiplier: r.Multiplier, Cap: r.Cap}, nil
+	case "full_jitter":
+		return FullJitterBackoff{Base: r.Base, Multiplier: r.Multiplier, Cap: r.Cap}, nil
+	case "decorrelated_jitter":
+		return DecorrelatedJitterBackoff{Base: r.Base, Cap: r.Cap}, nil
+	}
+	return nil, fmt.Errorf("unknown backoff strategy '%s'", r.Kind)
+}
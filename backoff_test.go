@@ -0,0 +1,109 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	exponential := ExponentialBackoff{Base: 100 * time.Millisecond, Cap: time.Second}
+	for attempt, expected := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		5:  time.Second,
+		80: time.Second,
+	} {
+		if d := exponential.Next(attempt, 0); d != expected {
+			t.Errorf("exponential attempt %d: expected %s, got %s", attempt, expected, d)
+		}
+	}
+	linear := LinearBackoff{Base: 10 * time.Millisecond, Step: 5 * time.Millisecond}
+	if d := linear.Next(3, 0); d != 20*time.Millisecond {
+		t.Errorf("linear: expected 20ms, got %s", d)
+	}
+	previous := time.Duration(0)
+	decorrelated := DecorrelatedJitterBackoff{Base: 10 * time.Millisecond, Cap: 50 * time.Millisecond}
+	full := FullJitterBackoff{Base: 10 * time.Millisecond, Cap: 50 * time.Millisecond}
+	for attempt := 1; attempt < 20; attempt++ {
+		previous = decorrelated.Next(attempt, previous)
+		if previous < 10*time.Millisecond || previous > 50*time.Millisecond {
+			t.Fatalf("decorrelated jitter out of bounds: %s", previous)
+		}
+		if d := full.Next(attempt, 0); d < 0 || d > 50*time.Millisecond {
+			t.Fatalf("full jitter out of bounds: %s", d)
+		}
+	}
+}
+
+func TestTask_SubSecondBackoff(t *testing.T) {
+	task := NewTask(func(ctx context.Context) error {
+		return errors.New("rate limited")
+	}).Retries(3).Backoff(ConstantBackoff{Interval: 250 * time.Millisecond})
+	before := Now()
+	next, err := task.Attempt(context.Background())
+	if err == nil || next.Sub(before) > time.Second {
+		t.Fatalf("expected a retry within a second, got %s", next.Sub(before))
+	}
+}
+
+type stepBackoff struct{}
+
+func (stepBackoff) Next(attempt int, previous time.Duration) time.Duration {
+	return time.Duration(attempt) * time.Second
+}
+
+func TestBackoff_Persisted(t *testing.T) {
+	for _, b := range []Backoff{
+		ConstantBackoff{Interval: 250 * time.Millisecond},
+		LinearBackoff{Base: time.Second, Step: time.Second, Cap: time.Minute},
+		ExponentialBackoff{Base: time.Second, Multiplier: 3, Cap: time.Minute},
+		FullJitterBackoff{Base: time.Second, Multiplier: 2, Cap: time.Minute},
+		DecorrelatedJitterBackoff{Base: time.Second, Cap: time.Minute},
+	} {
+		task, err := taskFromRecord(NewTypedTask("test.backoff", nil).Backoff(b).record())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.backoff != b {
+			t.Errorf("expected %#v after reload, got %#v", b, task.backoff)
+		}
+	}
+	for _, b := range []Backoff{
+		&ConstantBackoff{Interval: 250 * time.Millisecond},
+		&LinearBackoff{Base: time.Second, Step: time.Second, Cap: time.Minute},
+		&ExponentialBackoff{Base: time.Second, Multiplier: 3, Cap: time.Minute},
+		&FullJitterBackoff{Base: time.Second, Multiplier: 2, Cap: time.Minute},
+		&DecorrelatedJitterBackoff{Base: time.Second, Cap: time.Minute},
+	} {
+		task, err := taskFromRecord(NewTypedTask("test.backoff", nil).Backoff(b).record())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := reflect.ValueOf(b).Elem().Interface(); task.backoff != expected {
+			t.Errorf("expected %#v after reload, got %#v", expected, task.backoff)
+		}
+	}
+	if _, err := taskFromRecord(TaskRecord{ID: "x", Backoff: &BackoffRecord{Kind: "fibonacci"}}); err == nil {
+		t.Error("expected an unknown strategy to be rejected")
+	}
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterTaskType("test.backoff", func(ctx context.Context, payload []byte) error {
+		return nil
+	})
+	q := NewQueue("backoff")
+	q.Storage(store)
+	err = q.Enqueue(NewTypedTask("test.backoff", nil).Backoff(stepBackoff{}))
+	if !errors.Is(err, ErrBackoffNotPersistable) {
+		t.Fatalf("expected ErrBackoffNotPersistable, got %v", err)
+	}
+	if err := q.Enqueue(NewTask(func(ctx context.Context) error { return nil }).Backoff(stepBackoff{})); err != nil {
+		t.Fatalf("expected a function task, which isn't persisted, to be accepted: %v", err)
+	}
+}
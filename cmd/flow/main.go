@@ -0,0 +1,288 @@
+// Command flow validates, visualizes, runs and compares flow definitions.
+//
+// Usage:
+//
+//	flow validate FILE...
+//	flow graph [-format dot|mermaid] FILE
+//	flow run [-plugin FILE.so] [-echo] [-data FILE] FILE
+//	flow diff OLD NEW
+//
+// Files may be JSON, YAML or TOML, detected by extension or else by content.
+// validate exits with status 1 if any file is invalid, run if the flow fails
+// and diff if the flows differ; usage errors exit with status 2.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"plugin"
+
+	"github.com/sujit-baniya/flow"
+)
+
+const usage = `Usage:
+  flow validate FILE...
+  flow graph [-format dot|mermaid] FILE
+  flow run [-plugin FILE.so] [-echo] [-data FILE] FILE
+  flow diff OLD NEW
+`
+
+// errUsage Makes a command exit with status 2.
+var errUsage = errors.New("invalid usage")
+
+// errFailed Makes a command exit with status 1 after it reported why.
+var errFailed = errors.New("failed")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	commands := map[string]func(args []string, stdin io.Reader, stdout, stderr io.Writer) error{
+		"validate": validate,
+		"graph":    graph,
+		"run":      runFlow,
+		"diff":     diff,
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+	switch err := command(args[1:], stdin, stdout, stderr); {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprint(stderr, usage)
+		return 2
+	case errors.Is(err, errFailed):
+		return 1
+	default:
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+}
+
+// readFile Reads a flow definition and determines its format.
+func readFile(path string) ([]byte, flow.Format, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	format, ok := flow.FormatFromExt(path)
+	if !ok {
+		format = flow.DetectFormat(content)
+	}
+	return content, format, nil
+}
+
+// parseFile Reads and decodes a flow definition.
+func parseFile(path string) (*flow.RawFlow, error) {
+	content, format, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := flow.ParseRaw(content, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return raw, nil
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
+
+// parseFlags Parses the flags of a command. The flag package reports invalid
+// flags itself, so they are only turned into a usage error.
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+func validate(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	invalid := false
+	for _, path := range args {
+		content, format, err := readFile(path)
+		if err == nil {
+			err = flow.ValidateWithFormat(format, content)
+		}
+		var errs flow.ValidationErrors
+		switch {
+		case err == nil:
+			fmt.Fprintf(stdout, "%s: ok\n", path)
+			continue
+		case errors.As(err, &errs):
+			for _, e := range errs {
+				fmt.Fprintf(stderr, "%s: %v\n", path, e)
+			}
+		default:
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+		}
+		invalid = true
+	}
+	if invalid {
+		return errFailed
+	}
+	return nil
+}
+
+func graph(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := newFlagSet("graph", stderr)
+	format := flags.String("format", "dot", "output format: dot or mermaid")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	raw, err := parseFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "dot":
+		fmt.Fprint(stdout, raw.DOT())
+	case "mermaid":
+		fmt.Fprint(stdout, raw.Mermaid())
+	default:
+		return fmt.Errorf("unknown graph format %q", *format)
+	}
+	return nil
+}
+
+// echo Built-in handler which passes its data through, reporting the vertex.
+func echo(stderr io.Writer, vertex string) flow.Handler {
+	return func(ctx context.Context, data flow.Data) (flow.Data, error) {
+		fmt.Fprintf(stderr, "%s: %s\n", vertex, data.Payload)
+		return data, nil
+	}
+}
+
+// loadPlugin Opens a Go plugin, whose init functions register handlers with
+// flow.RegisterHandler. A "Register" function is called too, if it exists.
+func loadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	symbol, err := p.Lookup("Register")
+	if err != nil {
+		return nil
+	}
+	register, ok := symbol.(func())
+	if !ok {
+		return fmt.Errorf("%s: Register must be a func()", path)
+	}
+	register()
+	return nil
+}
+
+func runFlow(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := newFlagSet("run", stderr)
+	pluginPath := flags.String("plugin", "", "Go plugin registering the handlers")
+	useEcho := flags.Bool("echo", false, "pass data through vertices without a registered handler")
+	dataPath := flags.String("data", "-", "file with the payload, or - for stdin")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	if *pluginPath != "" {
+		if err := loadPlugin(*pluginPath); err != nil {
+			return err
+		}
+	}
+	content, format, err := readFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := flow.ValidateWithFormat(format, content); err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", flags.Arg(0), err)
+		return errFailed
+	}
+	var payload []byte
+	if *dataPath == "-" {
+		payload, err = io.ReadAll(stdin)
+	} else {
+		payload, err = os.ReadFile(*dataPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	f := flow.NewWithFormat(format, content).WithRegistry(flow.NewRegistry())
+	for _, vertex := range f.Raw().Vertices() {
+		if f.GetNodeHandler(vertex) != nil {
+			continue
+		}
+		if !*useEcho {
+			return fmt.Errorf("no handler registered for vertex '%s'; use -plugin or -echo", vertex)
+		}
+		f.AddNode(vertex, echo(stderr, vertex))
+	}
+	result, err := f.Build().Process(context.Background(), flow.Data{Payload: payload})
+	output := flow.FlowResult{
+		RequestID: result.RequestID,
+		Status:    result.Status,
+	}
+	if json.Valid(result.Payload) {
+		output.Payload = json.RawMessage(result.Payload)
+	} else if len(result.Payload) > 0 {
+		output.Payload, _ = json.Marshal(string(result.Payload))
+	}
+	if err != nil {
+		output.Status = flow.StatusFailed
+		output.FailedReason = err.Error()
+	} else if output.Status == "" {
+		output.Status = flow.StatusCompleted
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return err
+	}
+	if output.Status == flow.StatusFailed {
+		return errFailed
+	}
+	return nil
+}
+
+func diff(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	a, err := parseFile(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := parseFile(args[1])
+	if err != nil {
+		return err
+	}
+	changes := flow.Diff(a, b)
+	for _, change := range changes {
+		fmt.Fprintln(stdout, change)
+	}
+	if len(changes) > 0 {
+		return errFailed
+	}
+	return nil
+}
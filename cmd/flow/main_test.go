@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sujit-baniya/flow"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	orders := writeFile(t, dir, "orders.yaml", `key: orders
+edges:
+  - [receive, check]
+branches:
+  - key: check
+    conditional_nodes:
+      large: review
+      small: ship
+    conditions:
+      - status: large
+        field: total
+        operator: gte
+        value: 1000
+`)
+	changed := writeFile(t, dir, "changed.json", `{"key": "orders", "edges": [["receive", "check"]],
+		"branches": [{"key": "check", "conditional_nodes": {"large": "review", "small": "refund"}}]}`)
+	invalid := writeFile(t, dir, "invalid.json", `{"edges": [["a"]]}`)
+
+	cases := []struct {
+		name   string
+		args   []string
+		stdin  string
+		status int
+		stdout string
+	}{
+		{"no command", nil, "", 2, ""},
+		{"unknown command", []string{"lint", orders}, "", 2, ""},
+		{"validate", []string{"validate", orders, changed}, "", 0, orders + ": ok"},
+		{"validate invalid", []string{"validate", orders, invalid}, "", 1, orders + ": ok"},
+		{"validate missing file", []string{"validate", filepath.Join(dir, "missing.json")}, "", 1, ""},
+		{"validate without files", []string{"validate"}, "", 2, ""},
+		{"graph", []string{"graph", "-format", "mermaid", orders}, "", 0, "flowchart LR"},
+		{"graph unknown format", []string{"graph", "-format", "svg", orders}, "", 1, ""},
+		{"graph unknown flag", []string{"graph", "-colour", orders}, "", 2, ""},
+		{"run", []string{"run", "-echo", orders}, `{"total": 25}`, 0, `"status": "COMPLETED"`},
+		{"run data file", []string{"run", "-echo", "-data", changed, orders}, "", 0, `"status": "COMPLETED"`},
+		{"run failing flow", []string{"run", "-echo", orders}, "not json", 1, `"status": "FAILED"`},
+		{"run without handlers", []string{"run", orders}, "{}", 1, ""},
+		{"run invalid flow", []string{"run", "-echo", invalid}, "{}", 1, ""},
+		{"run without file", []string{"run", "-echo"}, "", 2, ""},
+		{"diff same", []string{"diff", orders, orders}, "", 0, ""},
+		{"diff changed", []string{"diff", orders, changed}, "", 1, "~ branches/check/small: ship -> refund"},
+		{"diff one file", []string{"diff", orders}, "", 2, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			status := run(c.args, strings.NewReader(c.stdin), &stdout, &stderr)
+			if status != c.status {
+				t.Errorf("expected exit status %d, got %d\nstdout: %s\nstderr: %s", c.status, status, stdout.String(), stderr.String())
+			}
+			if !strings.Contains(stdout.String(), c.stdout) {
+				t.Errorf("expected %q in stdout, got %s", c.stdout, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRun_Result(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "echo.json", `{"key": "echo", "nodes": ["only"]}`)
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"run", "-echo", path}, strings.NewReader(`{"id": 1}`), &stdout, &stderr); status != 0 {
+		t.Fatalf("expected exit status 0, got %d: %s", status, stderr.String())
+	}
+	var result flow.FlowResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]int
+	if err := json.Unmarshal(result.Payload, &payload); err != nil || payload["id"] != 1 {
+		t.Errorf("expected the input payload, got %s", result.Payload)
+	}
+	if result.Status != flow.StatusCompleted {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
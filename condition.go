@@ -0,0 +1,181 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// conditionOperators Operators a Condition may use. An empty operator is "eq".
+var conditionOperators = []string{"eq", "ne", "gt", "gte", "lt", "lte", "in", "contains", "exists"}
+
+// Condition Declarative test of the payload for a branch vertex. When the
+// branch handler doesn't set a status, the branch takes the Status of the first
+// condition which matches.
+type Condition struct {
+	// Status Condition of the branch, i.e. a key of its conditional nodes.
+	Status string `json:"status" yaml:"status" toml:"status"`
+
+	// Field Dot separated path into the JSON payload, e.g. "user.age". The
+	// whole payload is tested if it is empty.
+	Field string `json:"field,omitempty" yaml:"field,omitempty" toml:"field,omitempty"`
+
+	// Operator One of eq, ne, gt, gte, lt, lte, in, contains and exists.
+	// Defaults to eq.
+	Operator string `json:"operator,omitempty" yaml:"operator,omitempty" toml:"operator,omitempty"`
+
+	// Value Operand the field is compared with. For "in" it is a list, and for
+	// "exists" a boolean which defaults to true.
+	Value interface{} `json:"value,omitempty" yaml:"value,omitempty" toml:"value,omitempty"`
+}
+
+func (c Condition) String() string {
+	operator := c.Operator
+	if operator == "" {
+		operator = "eq"
+	}
+	field := c.Field
+	if field == "" {
+		field = "payload"
+	}
+	value, _ := json.Marshal(normalizeValue(c.Value))
+	return fmt.Sprintf("%s: %s %s %s", c.Status, field, operator, value)
+}
+
+// Match Reports whether the condition holds for a decoded JSON document.
+func (c Condition) Match(doc interface{}) (bool, error) {
+	actual, found := lookupField(doc, c.Field)
+	value := normalizeValue(c.Value)
+	switch c.Operator {
+	case "", "eq":
+		return found && reflect.DeepEqual(actual, value), nil
+	case "ne":
+		return !found || !reflect.DeepEqual(actual, value), nil
+	case "gt", "gte", "lt", "lte":
+		cmp, ok := compareValues(actual, value)
+		if !found || !ok {
+			return false, nil
+		}
+		switch c.Operator {
+		case "gt":
+			return cmp > 0, nil
+		case "gte":
+			return cmp >= 0, nil
+		case "lt":
+			return cmp < 0, nil
+		}
+		return cmp <= 0, nil
+	case "in":
+		values, ok := value.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("condition '%s': value of 'in' must be a list", c.Status)
+		}
+		for _, v := range values {
+			if found && reflect.DeepEqual(actual, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "contains":
+		switch a := actual.(type) {
+		case string:
+			s, ok := value.(string)
+			return ok && strings.Contains(a, s), nil
+		case []interface{}:
+			for _, v := range a {
+				if reflect.DeepEqual(v, value) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case "exists":
+		want, ok := value.(bool)
+		if value != nil && !ok {
+			return false, fmt.Errorf("condition '%s': value of 'exists' must be a boolean", c.Status)
+		}
+		return found == (want || value == nil), nil
+	}
+	return false, fmt.Errorf("condition '%s': unknown operator '%s'", c.Status, c.Operator)
+}
+
+// evaluateConditions Returns the status of the first condition matching a JSON
+// payload, or "" if none does.
+func evaluateConditions(conditions []Condition, payload Payload) (string, error) {
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return "", fmt.Errorf("conditions require a JSON payload: %w", err)
+	}
+	for _, condition := range conditions {
+		ok, err := condition.Match(doc)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return condition.Status, nil
+		}
+	}
+	return "", nil
+}
+
+// lookupField Returns the value at a dot separated path of a JSON document.
+func lookupField(doc interface{}, field string) (interface{}, bool) {
+	if field == "" {
+		return doc, true
+	}
+	for _, name := range strings.Split(field, ".") {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// normalizeValue Converts a value decoded from any format to the types
+// encoding/json decodes to, so it compares equal to payload values.
+func normalizeValue(value interface{}) interface{} {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+// compareValues Orders two numbers or two strings.
+func compareValues(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+// passThrough Handler of a branch vertex which only has conditions.
+func passThrough(ctx context.Context, data Data) (Data, error) {
+	return data, nil
+}
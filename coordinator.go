@@ -0,0 +1,487 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLeaseNotFound Returned when a lease doesn't exist, usually because it
+// expired and its task was handed to another worker.
+var ErrLeaseNotFound = errors.New("lease not found")
+
+// maxLeaseWait Upper limit for how long a lease request waits for a task.
+const maxLeaseWait = 30 * time.Second
+
+// lease A task attempt which waits for, or is being executed by, a worker.
+type lease struct {
+	id      string
+	typ     string
+	payload []byte
+	worker  string
+	timer   *time.Timer
+	done    chan error
+}
+
+// Coordinator Hands the attempts of remote task types on a queue to workers
+// which pull them over HTTP, so tasks can be executed on other machines
+// without a message broker. The queue keeps scheduling, retries and dead
+// letters; an attempt of a remote task waits until a worker reports its
+// result, and takes up one of the queue's workers meanwhile.
+//
+// Workers POST JSON to the endpoints "lease", "heartbeat" and "complete", see
+// Worker. A lease which isn't renewed by a heartbeat within the lease TTL
+// expires, and its attempt is handed to the next worker without consuming a
+// retry. Reports for leases which no longer exist get status 410.
+type Coordinator struct {
+	// LeaseTTL How long a lease is valid without a heartbeat. Defaults to 30
+	// seconds.
+	LeaseTTL time.Duration
+
+	// MaxBodySize Upper limit for the size of request bodies. Defaults to 10 MiB.
+	MaxBodySize int64
+
+	mutex   sync.Mutex
+	queue   *Queue
+	pending map[string][]*lease
+	leases  map[string]*lease
+	changed chan struct{}
+}
+
+// NewCoordinator Creates a coordinator for the remote task types of a queue.
+func NewCoordinator(queue *Queue) *Coordinator {
+	return &Coordinator{
+		LeaseTTL:    30 * time.Second,
+		MaxBodySize: defaultMaxBodySize,
+		queue:       queue,
+		pending:     make(map[string][]*lease),
+		leases:      make(map[string]*lease),
+		changed:     make(chan struct{}),
+	}
+}
+
+// Remote Registers a task type on the queue whose attempts are executed by
+// workers.
+func (c *Coordinator) Remote(taskType string) {
+	c.queue.RegisterTaskType(taskType, func(ctx context.Context, payload []byte) error {
+		return c.attempt(ctx, taskType, payload)
+	})
+}
+
+// attempt Offers an attempt to the workers and waits for its result.
+func (c *Coordinator) attempt(ctx context.Context, taskType string, payload []byte) error {
+	l := &lease{
+		id:      newID(),
+		typ:     taskType,
+		payload: payload,
+		done:    make(chan error, 1),
+	}
+	c.mutex.Lock()
+	c.offer(l, false)
+	c.mutex.Unlock()
+	select {
+	case err := <-l.done:
+		return err
+	case <-ctx.Done():
+		c.mutex.Lock()
+		c.withdraw(l)
+		c.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+// offer Makes a lease available to workers. Must be called with the mutex
+// held.
+func (c *Coordinator) offer(l *lease, first bool) {
+	c.leases[l.id] = l
+	if first {
+		c.pending[l.typ] = append([]*lease{l}, c.pending[l.typ]...)
+	} else {
+		c.pending[l.typ] = append(c.pending[l.typ], l)
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// withdraw Removes a lease whether it is pending or leased. Must be called
+// with the mutex held.
+func (c *Coordinator) withdraw(l *lease) {
+	delete(c.leases, l.id)
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	pending := c.pending[l.typ]
+	for i, p := range pending {
+		if p == l {
+			c.pending[l.typ] = append(pending[:i:i], pending[i+1:]...)
+			break
+		}
+	}
+	if len(c.pending[l.typ]) == 0 {
+		delete(c.pending, l.typ)
+	}
+}
+
+// expire Hands an expired lease to the next worker under a new ID, so the
+// previous worker can't report it.
+func (c *Coordinator) expire(l *lease) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.leases[l.id] != l || l.worker == "" {
+		return
+	}
+	log.Printf("Lease %s of task type %s expired on worker %s", l.id, l.typ, l.worker)
+	delete(c.leases, l.id)
+	l.id = newID()
+	l.worker = ""
+	l.timer = nil
+	c.offer(l, true)
+}
+
+// take Leases the first pending attempt of the given types to a worker, or
+// returns nil. Must be called with the mutex held.
+func (c *Coordinator) take(worker string, types []string) *lease {
+	for _, typ := range types {
+		pending := c.pending[typ]
+		if len(pending) == 0 {
+			continue
+		}
+		l := pending[0]
+		if c.pending[typ] = pending[1:]; len(c.pending[typ]) == 0 {
+			delete(c.pending, typ)
+		}
+		l.worker = worker
+		l.timer = time.AfterFunc(c.LeaseTTL, func() {
+			c.expire(l)
+		})
+		return l
+	}
+	return nil
+}
+
+type leaseRequest struct {
+	Worker     string   `json:"worker"`
+	Types      []string `json:"types"`
+	WaitMillis int64    `json:"wait_ms"`
+}
+
+type leaseGrant struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Payload   []byte `json:"payload"`
+	TTLMillis int64  `json:"ttl_ms"`
+}
+
+type leaseReport struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	decode := func(v interface{}) bool {
+		body, err := readBody(w, r, c.MaxBodySize)
+		if err == nil {
+			err = json.Unmarshal(body, v)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return false
+		}
+		return true
+	}
+	endpoint := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	switch endpoint {
+	case "lease":
+		var req leaseRequest
+		if !decode(&req) {
+			return
+		}
+		grant, ok := c.lease(r.Context(), req)
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(grant)
+	case "heartbeat", "complete":
+		var report leaseReport
+		if !decode(&report) {
+			return
+		}
+		var err error
+		if endpoint == "heartbeat" {
+			err = c.heartbeat(report.ID)
+		} else {
+			err = c.complete(report)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// lease Waits up to the requested time for an attempt of one of the types.
+func (c *Coordinator) lease(ctx context.Context, req leaseRequest) (leaseGrant, bool) {
+	wait := time.Duration(req.WaitMillis) * time.Millisecond
+	if wait > maxLeaseWait {
+		wait = maxLeaseWait
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		var grant leaseGrant
+		c.mutex.Lock()
+		l := c.take(req.Worker, req.Types)
+		if l != nil {
+			grant = leaseGrant{
+				ID:        l.id,
+				Type:      l.typ,
+				Payload:   l.payload,
+				TTLMillis: c.LeaseTTL.Milliseconds(),
+			}
+		}
+		changed := c.changed
+		c.mutex.Unlock()
+		if l != nil {
+			return grant, true
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return leaseGrant{}, false
+		case <-ctx.Done():
+			return leaseGrant{}, false
+		}
+	}
+}
+
+func (c *Coordinator) heartbeat(id string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	l, ok := c.leases[id]
+	if !ok || l.timer == nil {
+		return fmt.Errorf("%w: '%s'", ErrLeaseNotFound, id)
+	}
+	// If the timer has already fired, expire is waiting for the mutex and
+	// hands the lease to another worker, so this one must give it up.
+	if !l.timer.Stop() {
+		return fmt.Errorf("%w: '%s' expired", ErrLeaseNotFound, id)
+	}
+	l.timer.Reset(c.LeaseTTL)
+	return nil
+}
+
+func (c *Coordinator) complete(report leaseReport) error {
+	c.mutex.Lock()
+	l, ok := c.leases[report.ID]
+	if !ok || l.timer == nil {
+		c.mutex.Unlock()
+		return fmt.Errorf("%w: '%s'", ErrLeaseNotFound, report.ID)
+	}
+	c.withdraw(l)
+	c.mutex.Unlock()
+	var err error
+	if report.Error != "" {
+		err = errors.New(report.Error)
+	}
+	l.done <- err
+	return nil
+}
+
+// Worker Executes tasks leased from a Coordinator.
+type Worker struct {
+	// Coordinator URL of the coordinator, e.g. "http://coordinator:8080/tasks".
+	Coordinator string
+
+	// Name Identifies the worker in the coordinator's logs. Defaults to a
+	// random ID.
+	Name string
+
+	// Client Used for requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Concurrency Number of tasks executed at once. Defaults to 1.
+	Concurrency int
+
+	// Wait How long each lease request waits for a task. Defaults to 10
+	// seconds.
+	Wait time.Duration
+
+	mutex sync.RWMutex
+	types map[string]TaskTypeFunc
+}
+
+// NewWorker Creates a worker for the coordinator at the given URL.
+func NewWorker(coordinator string) *Worker {
+	return &Worker{
+		Coordinator: coordinator,
+		Name:        newID(),
+		Concurrency: 1,
+		Wait:        10 * time.Second,
+		types:       make(map[string]TaskTypeFunc),
+	}
+}
+
+// Handle Sets the function executing tasks of a type. The worker only leases
+// tasks of types it handles.
+func (w *Worker) Handle(taskType string, fn TaskTypeFunc) {
+	w.mutex.Lock()
+	w.types[taskType] = fn
+	w.mutex.Unlock()
+}
+
+// Run Leases and executes tasks until ctx is cancelled. Attempts which are
+// running when ctx is cancelled are abandoned, and their leases expire.
+func (w *Worker) Run(ctx context.Context) error {
+	n := w.Concurrency
+	if n < 1 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if err := w.runOnce(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Worker %s: %v", w.Name, err)
+					select {
+					case <-time.After(time.Second):
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// runOnce Leases one task, if any, and executes it while sending heartbeats.
+func (w *Worker) runOnce(ctx context.Context) error {
+	w.mutex.RLock()
+	types := make([]string, 0, len(w.types))
+	for typ := range w.types {
+		types = append(types, typ)
+	}
+	w.mutex.RUnlock()
+
+	var grant leaseGrant
+	ok, err := w.post(ctx, "lease", leaseRequest{
+		Worker:     w.Name,
+		Types:      types,
+		WaitMillis: w.Wait.Milliseconds(),
+	}, &grant)
+	if err != nil || !ok {
+		return err
+	}
+	w.mutex.RLock()
+	fn := w.types[grant.Type]
+	w.mutex.RUnlock()
+
+	// Heartbeats renew the lease; if it was lost, the attempt is abandoned
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		interval := time.Duration(grant.TTLMillis) * time.Millisecond / 3
+		if interval <= 0 {
+			interval = time.Millisecond
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := w.post(runCtx, "heartbeat", leaseReport{ID: grant.ID}, nil); err != nil {
+					if errors.Is(err, ErrLeaseNotFound) {
+						cancel()
+						return
+					}
+				}
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+	report := leaseReport{ID: grant.ID}
+	if fn == nil {
+		report.Error = fmt.Sprintf("%v: '%s'", ErrUnknownTaskType, grant.Type)
+	} else if err := runTaskFunc(runCtx, fn, grant.Payload); err != nil {
+		report.Error = err.Error()
+	}
+	if runCtx.Err() != nil {
+		return nil
+	}
+	cancel()
+	_, err = w.post(ctx, "complete", report, nil)
+	if errors.Is(err, ErrLeaseNotFound) {
+		log.Printf("Worker %s: result of lease %s discarded: %v", w.Name, grant.ID, err)
+		return nil
+	}
+	return err
+}
+
+func runTaskFunc(ctx context.Context, fn TaskTypeFunc, payload []byte) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return fn(ctx, payload)
+}
+
+// post Sends a request to the coordinator and decodes the response into out.
+// It reports false if the coordinator responded with no content.
+func (w *Worker) post(ctx context.Context, endpoint string, in, out interface{}) (bool, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return false, err
+	}
+	url := strings.TrimSuffix(w.Coordinator, "/") + "/" + endpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		if out == nil {
+			return true, nil
+		}
+		return true, json.NewDecoder(res.Body).Decode(out)
+	case http.StatusNoContent:
+		return false, nil
+	case http.StatusGone:
+		return false, ErrLeaseNotFound
+	default:
+		b, _ := io.ReadAll(res.Body)
+		return false, fmt.Errorf("coordinator responded with status %d: %s", res.StatusCode, strings.TrimSpace(string(b)))
+	}
+}
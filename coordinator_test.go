@@ -0,0 +1,129 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoordinator(t *testing.T) {
+	q := NewQueue("remote")
+	q.Workers(4)
+	coordinator := NewCoordinator(q)
+	coordinator.LeaseTTL = 50 * time.Millisecond
+	coordinator.Remote("test.resize")
+	server := httptest.NewServer(http.StripPrefix("/tasks", coordinator))
+	defer server.Close()
+
+	var mutex sync.Mutex
+	processed := make(map[string]string)
+	var wg sync.WaitGroup
+	wg.Add(6)
+	for i := 0; i < 6; i++ {
+		task := NewTypedTask("test.resize", []byte{byte('a' + i)}).After(func(ctx context.Context, task *Task) {
+			if task.Result() != nil {
+				t.Errorf("task %s failed: %v", task.Payload(), task.Result())
+			}
+			wg.Done()
+		})
+		if err := q.Enqueue(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Start(context.Background())
+
+	// A worker which leases a task and disappears without reporting it
+	body, _ := json.Marshal(leaseRequest{Worker: "crashed", Types: []string{"test.resize"}, WaitMillis: 1000})
+	res, err := http.Post(server.URL+"/tasks/lease", "application/json", bytes.NewReader(body))
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("expected a lease, got %v (%v)", res, err)
+	}
+	var abandoned leaseGrant
+	_ = json.NewDecoder(res.Body).Decode(&abandoned)
+	res.Body.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		worker := NewWorker(server.URL + "/tasks")
+		worker.Name = string(rune('x' + i))
+		worker.Wait = 100 * time.Millisecond
+		worker.Handle("test.resize", func(ctx context.Context, payload []byte) error {
+			time.Sleep(20 * time.Millisecond)
+			mutex.Lock()
+			processed[string(payload)] = worker.Name
+			mutex.Unlock()
+			return nil
+		})
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			_ = worker.Run(ctx)
+		}()
+	}
+	wg.Wait()
+	cancel()
+	workers.Wait()
+	q.Shutdown()
+
+	if len(processed) != 6 {
+		t.Fatalf("expected all 6 tasks to be processed, got %v", processed)
+	}
+	if _, ok := processed[string(abandoned.Payload)]; !ok {
+		t.Fatalf("expected the abandoned task %s to be re-leased", abandoned.Payload)
+	}
+	report, _ := json.Marshal(leaseReport{ID: abandoned.ID})
+	res, err = http.Post(server.URL+"/tasks/complete", "application/json", bytes.NewReader(report))
+	if err != nil || res.StatusCode != http.StatusGone {
+		t.Fatalf("expected the expired lease to be gone, got %v (%v)", res, err)
+	}
+	res.Body.Close()
+}
+
+func TestCoordinator_HeartbeatAfterExpiry(t *testing.T) {
+	c := NewCoordinator(NewQueue("coordinator-expiry"))
+	c.LeaseTTL = time.Millisecond
+
+	// Hold the mutex while the lease expires, so its heartbeat races with
+	// the pending expire.
+	c.mutex.Lock()
+	c.offer(&lease{id: "expiring", typ: "remote.expiry", done: make(chan error, 1)}, false)
+	id := c.take("worker-1", []string{"remote.expiry"}).id
+	time.Sleep(20 * time.Millisecond)
+	c.mutex.Unlock()
+
+	if err := c.heartbeat(id); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("expected ErrLeaseNotFound for an expired lease, got %v", err)
+	}
+}
+
+func TestCoordinator_MaxBodySize(t *testing.T) {
+	c := NewCoordinator(NewQueue("coordinator-body"))
+	c.MaxBodySize = 32
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	large, _ := json.Marshal(leaseReport{ID: "lease", Error: strings.Repeat("x", 64)})
+	for body, expected := range map[string]int{
+		string(large): http.StatusRequestEntityTooLarge,
+		`{"id":`:      http.StatusBadRequest,
+	} {
+		for _, endpoint := range []string{"/lease", "/heartbeat", "/complete"} {
+			res, err := http.Post(server.URL+endpoint, "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != expected {
+				t.Errorf("%s: expected %d, got %d", endpoint, expected, res.StatusCode)
+			}
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCron Returned when a cron expression can't be parsed.
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+// Schedule Computes the activation times of a recurring job.
+type Schedule interface {
+	// Next Returns the first activation time strictly after t, or the zero
+	// time if there is none.
+	Next(t time.Time) time.Time
+}
+
+// Every Returns a schedule which activates at a fixed interval.
+func Every(interval time.Duration) Schedule {
+	if interval <= 0 {
+		panic(errors.New("invalid input to Every"))
+	}
+	return intervalSchedule(interval)
+}
+
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// CronSchedule Schedule described by a cron expression.
+type CronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	location                              *time.Location
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondField = cronField{min: 0, max: 59}
+	minuteField = cronField{min: 0, max: 59}
+	hourField   = cronField{min: 0, max: 23}
+	domField    = cronField{min: 1, max: 31}
+	monthField  = cronField{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = cronField{min: 0, max: 6, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// starBit Marks a day field given as '*' or '?', so day-of-month and
+// day-of-week are combined the way cron does.
+const starBit = 1 << 63
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// ParseCron Parses a standard 5-field cron expression (minute hour
+// day-of-month month day-of-week) or a 6-field one with leading seconds.
+// Fields support '*', '?', lists, ranges, steps and month and weekday names;
+// the descriptors @yearly, @monthly, @weekly, @daily and @hourly are accepted
+// too. A "CRON_TZ=<zone>" or "TZ=<zone>" prefix sets the time zone, which
+// otherwise defaults to time.Local.
+func ParseCron(expr string) (*CronSchedule, error) {
+	return ParseCronIn(expr, time.Local)
+}
+
+// ParseCronIn Parses a cron expression like ParseCron, evaluating it in the
+// given time zone unless the expression has its own prefix.
+func ParseCronIn(expr string, location *time.Location) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		zone := fields[0][strings.Index(fields[0], "=")+1:]
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCron, err)
+		}
+		location = loc
+		fields = fields[1:]
+	}
+	if len(fields) == 1 {
+		descriptor, ok := cronDescriptors[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown descriptor '%s'", ErrInvalidCron, fields[0])
+		}
+		fields = strings.Fields(descriptor)
+	}
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("%w: expected 5 or 6 fields, got %d in '%s'", ErrInvalidCron, len(fields), expr)
+	}
+	s := &CronSchedule{location: location}
+	var err error
+	for i, target := range []*uint64{&s.second, &s.minute, &s.hour, &s.dom, &s.month, &s.dow} {
+		field := []cronField{secondField, minuteField, hourField, domField, monthField, dowField}[i]
+		if *target, err = parseCronField(fields[i], field); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func parseCronField(expr string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("%w: invalid step in '%s'", ErrInvalidCron, part)
+			}
+			part = part[:i]
+		}
+		low, high := field.min, field.max
+		switch {
+		case part == "*" || part == "?":
+			if step == 1 {
+				bits |= starBit
+			}
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = field.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if high, err = field.value(bounds[1]); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if low, err = field.value(part); err != nil {
+				return 0, err
+			}
+			if step == 1 {
+				high = low
+			}
+		}
+		if low > high {
+			return 0, fmt.Errorf("%w: range '%s' is reversed", ErrInvalidCron, part)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	// Sunday may be written as 7
+	if err == nil && f.max == 6 && v == 7 {
+		v = 0
+	}
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%w: value '%s' is out of range %d-%d", ErrInvalidCron, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Location Returns the time zone the schedule is evaluated in.
+func (s *CronSchedule) Location() *time.Location {
+	return s.location
+}
+
+// dayMatches Applies cron's rule that if both day-of-month and day-of-week are
+// restricted, a day matching either of them matches.
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.dom&starBit != 0 || s.dow&starBit != 0 {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next Implements Schedule. The result is in the location of t.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	original := t.Location()
+	t = t.In(s.location).Add(time.Second - time.Duration(t.Nanosecond())).Truncate(time.Second)
+	// Give up if nothing matches within five years, e.g. for "0 0 30 2 *"
+	limit := t.Year() + 5
+
+wrap:
+	for t.Year() <= limit {
+		for s.month&(1<<uint(t.Month())) == 0 {
+			t = wallClock(t.Year(), t.Month()+1, 1, 0, s.location)
+			if t.Month() == time.January {
+				continue wrap
+			}
+		}
+		for !s.dayMatches(t) {
+			t = wallClock(t.Year(), t.Month(), t.Day()+1, 0, s.location)
+			if t.Day() == 1 {
+				continue wrap
+			}
+		}
+		for s.hour&(1<<uint(t.Hour())) == 0 {
+			t = wallClock(t.Year(), t.Month(), t.Day(), t.Hour()+1, s.location)
+			if t.Hour() == 0 {
+				continue wrap
+			}
+		}
+		for s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			if t.Minute() == 0 {
+				continue wrap
+			}
+		}
+		for s.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			if t.Second() == 0 {
+				continue wrap
+			}
+		}
+		return t.In(original)
+	}
+	return time.Time{}
+}
+
+// wallClock Returns the start of an hour in a location. An hour skipped by a
+// daylight saving time transition moves to the first hour after it.
+func wallClock(year int, month time.Month, day, hour int, location *time.Location) time.Time {
+	for {
+		want := time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+		t := time.Date(year, month, day, hour, 0, 0, 0, location)
+		if t.Day() == want.Day() && t.Hour() == want.Hour() {
+			return t
+		}
+		hour++
+	}
+}
@@ -0,0 +1,313 @@
+package flow
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	utc := time.UTC
+	from := time.Date(2024, 1, 31, 10, 17, 30, 0, utc)
+	cases := map[string]time.Time{
+		"*/15 * * * *":                 time.Date(2024, 1, 31, 10, 30, 0, 0, utc),
+		"0 9 * * MON-FRI":              time.Date(2024, 2, 1, 9, 0, 0, 0, utc),
+		"30 * * * * *":                 time.Date(2024, 1, 31, 10, 18, 30, 0, utc),
+		"0 0 29 2 *":                   time.Date(2024, 2, 29, 0, 0, 0, 0, utc),
+		"@monthly":                     time.Date(2024, 2, 1, 0, 0, 0, 0, utc),
+		"0 0 1,15 * sun":               time.Date(2024, 2, 1, 0, 0, 0, 0, utc),
+		"CRON_TZ=Asia/Tokyo 0 9 * * *": time.Date(2024, 2, 1, 0, 0, 0, 0, utc),
+	}
+	for expr, expected := range cases {
+		s, err := ParseCronIn(expr, utc)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if next := s.Next(from); !next.Equal(expected) {
+			t.Errorf("%s: expected %s, got %s", expr, expected, next)
+		}
+	}
+	for _, expr := range []string{"* * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "@fortnightly"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+func TestScheduler_CatchUpAndOverlap(t *testing.T) {
+	var mutex sync.Mutex
+	now := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	advance := func(d time.Duration) {
+		mutex.Lock()
+		now = now.Add(d)
+		mutex.Unlock()
+	}
+	q := NewQueue("scheduler")
+	q.Workers(4)
+	q.Now(func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	})
+	s := NewScheduler(q)
+	ran := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var runs int32
+	err := s.Add(RecurringJob{
+		Name:     "report",
+		Schedule: Every(50 * time.Millisecond),
+		Overlap:  OverlapSkip,
+		CatchUp:  CatchUpAll,
+		LastRun:  now.Add(-170 * time.Millisecond),
+		Fn: func(ctx context.Context) error {
+			ran <- struct{}{}
+			if atomic.AddInt32(&runs, 1) > 3 {
+				<-release
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRuns := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case <-ran:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expected %d more runs", n-i)
+			}
+		}
+		select {
+		case <-ran:
+			t.Fatal("unexpected run")
+		default:
+		}
+	}
+	// activate Dispatches a due activation and waits until it has scheduled
+	// the next one.
+	activate := func(next time.Time) {
+		t.Helper()
+		q.Dispatch(context.Background())
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+			if at, _ := s.Next("report"); at.Equal(next) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the next activation at %s", next)
+			}
+		}
+	}
+	start := now
+
+	// Three missed activations are caught up
+	q.Dispatch(context.Background())
+	expectRuns(3)
+	q.inflight.Wait()
+
+	// The scheduled activation runs, and blocks
+	advance(60 * time.Millisecond)
+	activate(start.Add(100 * time.Millisecond))
+	q.inflight.Wait()
+	q.Dispatch(context.Background())
+	expectRuns(1)
+
+	// Every further activation is skipped while the run is blocked
+	for _, next := range []time.Duration{150, 200} {
+		advance(50 * time.Millisecond)
+		activate(start.Add(next * time.Millisecond))
+		q.Dispatch(context.Background())
+		expectRuns(0)
+		pending := 0
+		for _, task := range q.Tasks() {
+			if task.Metadata["schedule"] == "report" {
+				pending++
+			}
+		}
+		if pending != 1 {
+			t.Fatalf("expected only the blocked run, got %d runs", pending)
+		}
+	}
+	if n := atomic.LoadInt32(&runs); n != 4 {
+		t.Errorf("expected 3 catch-up runs and 1 scheduled run, got %d", n)
+	}
+	s.Remove("report")
+	close(release)
+	q.inflight.Wait()
+}
+
+// fakeClock Time source of a queue which only moves when advanced.
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	c.mutex.Unlock()
+}
+
+// activateJob Dispatches a due activation of a job and waits until it has
+// scheduled the next one.
+func activateJob(t *testing.T, q *Queue, s *Scheduler, name string, next time.Time) {
+	t.Helper()
+	q.Dispatch(context.Background())
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if at, _ := s.Next(name); at.Equal(next) {
+			return
+		}
+		if time.Now().After(deadline) {
+			at, _ := s.Next(name)
+			t.Fatalf("expected the next activation of %s at %s, got %s", name, next, at)
+		}
+	}
+}
+
+func TestScheduler_OverlapQueue(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)}
+	start := clock.Now()
+	q := NewQueue("scheduler-queue")
+	q.Workers(4)
+	q.Now(clock.Now)
+	s := NewScheduler(q)
+	release := make(chan struct{})
+	var runs int32
+	err := s.Add(RecurringJob{
+		Name:     "sync",
+		Schedule: Every(50 * time.Millisecond),
+		Overlap:  OverlapQueue,
+		Fn: func(ctx context.Context) error {
+			if atomic.AddInt32(&runs, 1) == 1 {
+				<-release
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first activation runs and blocks
+	clock.Advance(60 * time.Millisecond)
+	activateJob(t, q, s, "sync", start.Add(100*time.Millisecond))
+	q.inflight.Wait()
+	q.Dispatch(context.Background())
+
+	// Two more activations are queued behind it
+	for _, next := range []time.Duration{150, 200} {
+		clock.Advance(50 * time.Millisecond)
+		activateJob(t, q, s, "sync", start.Add(next*time.Millisecond))
+		q.Dispatch(context.Background())
+	}
+	for _, task := range q.Tasks() {
+		if task.Metadata["schedule"] == "sync" && !task.Running {
+			t.Fatalf("expected queued activations to wait for the running one, got %+v", task)
+		}
+	}
+
+	// Once it finishes, the queued activations run one after another
+	close(release)
+	for i := 0; i < 5; i++ {
+		q.inflight.Wait()
+		q.Dispatch(context.Background())
+	}
+	q.inflight.Wait()
+	if n := atomic.LoadInt32(&runs); n != 3 {
+		t.Fatalf("expected the 2 queued activations to run, got %d runs", n)
+	}
+	s.Remove("sync")
+}
+
+func TestScheduler_AddFlow(t *testing.T) {
+	registry := NewRegistry()
+	received := make(chan string, 1)
+	f := New().WithRegistry(registry).AddNode("send", func(ctx context.Context, d Data) (Data, error) {
+		received <- string(d.Payload)
+		return d, nil
+	})
+	f.Key = "digest"
+	if f.Build().Error != nil {
+		t.Fatal(f.Error)
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)}
+	q := NewQueue("scheduler-flow")
+	q.Now(clock.Now)
+	s := NewScheduler(q)
+	err := s.AddFlow(RecurringJob{Name: "digest", Schedule: Every(time.Hour)}, registry, "", "digest", Data{Payload: Payload(`"daily"`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour + time.Second)
+	activateJob(t, q, s, "digest", clock.Now().Add(-time.Second).Add(time.Hour))
+	q.inflight.Wait()
+	q.Dispatch(context.Background())
+	q.inflight.Wait()
+	select {
+	case payload := <-received:
+		if payload != `"daily"` {
+			t.Fatalf("expected the static payload, got %s", payload)
+		}
+	default:
+		t.Fatal("expected the flow to run")
+	}
+	s.Remove("digest")
+}
+
+func TestScheduler_TimeZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	schedule, err := ParseCronIn("0 9 * * *", newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2:30 doesn't exist on the day daylight saving time starts
+	skipped, err := ParseCronIn("30 2 * * *", newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := skipped.Next(time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)); !next.Equal(time.Date(2024, 3, 11, 6, 30, 0, 0, time.UTC)) {
+		t.Fatalf("expected the skipped time to move to the next day, got %s", next)
+	}
+
+	// 8:59 in New York the day before daylight saving time starts
+	clock := &fakeClock{now: time.Date(2024, 3, 9, 13, 59, 0, 0, time.UTC)}
+	q := NewQueue("scheduler-zone")
+	q.Now(clock.Now)
+	s := NewScheduler(q)
+	var runs int32
+	err = s.Add(RecurringJob{Name: "standup", Schedule: schedule, Fn: func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := s.Next("standup"); !next.Equal(time.Date(2024, 3, 9, 14, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected 9:00 EST, got %s", next)
+	}
+
+	// The next day 9:00 is an hour earlier in UTC
+	clock.Advance(time.Minute + time.Second)
+	activateJob(t, q, s, "standup", time.Date(2024, 3, 10, 13, 0, 0, 0, time.UTC))
+	q.inflight.Wait()
+	q.Dispatch(context.Background())
+	q.inflight.Wait()
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Fatalf("expected one run, got %d", n)
+	}
+	s.Remove("standup")
+}
@@ -2,6 +2,7 @@ package flow
 
 import (
 	"encoding/json"
+	"errors"
 )
 
 type Payload []byte
@@ -17,6 +18,7 @@ type Data struct {
 	Payload      Payload      `json:"payload"`
 	Status       string       `json:"status"`
 	Flow         string       `json:"flow"`
+	FlowVersion  int          `json:"flow_version"`
 	Operation    string       `json:"operation"`
 	FailedReason error        `json:"failed_reason"`
 	UserID       uint         `json:"user_id"`
@@ -26,6 +28,37 @@ type Data struct {
 	Attachments  []Attachment `json:"attachments"`
 }
 
+// MarshalJSON Encodes the data with FailedReason as its error message, so Data
+// survives a round trip through JSON.
+func (d Data) MarshalJSON() ([]byte, error) {
+	type data Data
+	aux := struct {
+		data
+		FailedReason string `json:"failed_reason,omitempty"`
+	}{data: data(d)}
+	if d.FailedReason != nil {
+		aux.FailedReason = d.FailedReason.Error()
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON Decodes data encoded by MarshalJSON.
+func (d *Data) UnmarshalJSON(b []byte) error {
+	type data Data
+	aux := struct {
+		*data
+		FailedReason string `json:"failed_reason,omitempty"`
+	}{data: (*data)(d)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	d.FailedReason = nil
+	if aux.FailedReason != "" {
+		d.FailedReason = errors.New(aux.FailedReason)
+	}
+	return nil
+}
+
 func (d *Data) UnmarshalBinary(data []byte) error {
 	return json.Unmarshal(data, d)
 }
@@ -0,0 +1,222 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDeadLetterNotFound Returned when re-driving a dead letter which doesn't
+	// exist.
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+	// ErrNotRedrivable Returned when re-driving the dead letter of a function
+	// task from a store which only kept its record, since its function can't be
+	// restored.
+	ErrNotRedrivable = errors.New("dead letter can't be re-driven")
+)
+
+// DeadLetter Records a task which exhausted its retries.
+type DeadLetter struct {
+	Task      TaskRecord `json:"task"`
+	LastError string     `json:"last_error"`
+	FailedAt  time.Time  `json:"failed_at"`
+
+	// task keeps function tasks, which can't be serialized, re-drivable by
+	// stores which hold dead letters in memory.
+	task *Task
+}
+
+// DeadLetterStore Keeps the tasks of queues which exhausted their retries.
+type DeadLetterStore interface {
+	// Put Records a dead letter.
+	Put(queue string, letter DeadLetter) error
+
+	// List Returns the dead letters of a queue, oldest first.
+	List(queue string) ([]DeadLetter, error)
+
+	// Take Removes and returns a dead letter, or ErrDeadLetterNotFound.
+	Take(queue string, id string) (DeadLetter, error)
+}
+
+// MemoryDeadLetters DeadLetterStore which keeps dead letters in memory. It is
+// the default store of a queue and can re-drive function tasks as well as
+// typed tasks.
+type MemoryDeadLetters struct {
+	mutex   sync.Mutex
+	letters map[string][]DeadLetter
+}
+
+// NewMemoryDeadLetters Creates an empty in-memory dead letter store.
+func NewMemoryDeadLetters() *MemoryDeadLetters {
+	return &MemoryDeadLetters{
+		letters: make(map[string][]DeadLetter),
+	}
+}
+
+// Put Implements DeadLetterStore.
+func (s *MemoryDeadLetters) Put(queue string, letter DeadLetter) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.letters[queue] = append(s.letters[queue], letter)
+	return nil
+}
+
+// List Implements DeadLetterStore.
+func (s *MemoryDeadLetters) List(queue string) ([]DeadLetter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]DeadLetter(nil), s.letters[queue]...), nil
+}
+
+// Take Implements DeadLetterStore.
+func (s *MemoryDeadLetters) Take(queue string, id string) (DeadLetter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	letters := s.letters[queue]
+	for i, letter := range letters {
+		if letter.Task.ID == id {
+			s.letters[queue] = append(letters[:i], letters[i+1:]...)
+			return letter, nil
+		}
+	}
+	return DeadLetter{}, fmt.Errorf("%w: '%s'", ErrDeadLetterNotFound, id)
+}
+
+// Put Implements DeadLetterStore.
+func (s *FileStore) Put(queue string, letter DeadLetter) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(s.path(queue, "dead", letter.Task.ID+".json"), letter)
+}
+
+// List Implements DeadLetterStore.
+func (s *FileStore) List(queue string) ([]DeadLetter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var letters []DeadLetter
+	err := s.readAll(s.path(queue, "dead"), func(b []byte) error {
+		var letter DeadLetter
+		if err := json.Unmarshal(b, &letter); err != nil {
+			return err
+		}
+		letters = append(letters, letter)
+		return nil
+	})
+	sort.SliceStable(letters, func(i, j int) bool {
+		return letters[i].FailedAt.Before(letters[j].FailedAt)
+	})
+	return letters, err
+}
+
+// Take Implements DeadLetterStore.
+func (s *FileStore) Take(queue string, id string) (DeadLetter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path := s.path(queue, "dead", id+".json")
+	var letter DeadLetter
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return letter, fmt.Errorf("%w: '%s'", ErrDeadLetterNotFound, id)
+	}
+	if err := json.Unmarshal(b, &letter); err != nil {
+		return letter, err
+	}
+	return letter, s.remove(path)
+}
+
+// DeadLetterStorage Sets the store which records tasks of this queue that
+// exhausted their retries. Defaults to a MemoryDeadLetters store.
+func (q *Queue) DeadLetterStorage(store DeadLetterStore) {
+	q.mutex.Lock()
+	q.deadLetters = store
+	q.mutex.Unlock()
+}
+
+// DeadLetters Returns the tasks of this queue which exhausted their retries.
+func (q *Queue) DeadLetters() ([]DeadLetter, error) {
+	return q.deadLetterStore().List(q.name)
+}
+
+// deadLetterStore Returns the dead letter store, which DeadLetterStorage may
+// replace concurrently.
+func (q *Queue) deadLetterStore() DeadLetterStore {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.deadLetters
+}
+
+// bury Records a task which exhausted its retries. Must be called with the
+// mutex held.
+func (q *Queue) bury(task *Task) {
+	letter := DeadLetter{
+		Task:     task.record(),
+		FailedAt: q.now(),
+		task:     task,
+	}
+	if err := task.LastError(); err != nil {
+		letter.LastError = err.Error()
+	}
+	if err := q.deadLetters.Put(q.name, letter); err != nil {
+		log.Printf("Failed to record dead letter for task %s: %v", task.id, err)
+	}
+}
+
+// Redrive Removes a task from the dead letters and enqueues it again with a
+// fresh retry budget. Its own 'After' function, if any, has already run and is
+// not called again; one set with AfterTaskType is. Function tasks can only be
+// re-driven from a store which keeps them in memory, and otherwise return
+// ErrNotRedrivable and stay in the dead letters.
+func (q *Queue) Redrive(id string) error {
+	store := q.deadLetterStore()
+	letter, err := store.Take(q.name, id)
+	if err != nil {
+		return err
+	}
+	task := letter.task
+	if task == nil {
+		if letter.Task.Type == "" {
+			err = fmt.Errorf("%w: task '%s' has no type", ErrNotRedrivable, id)
+		} else {
+			task, err = taskFromRecord(letter.Task)
+		}
+		if err != nil {
+			_ = store.Put(q.name, letter)
+			return err
+		}
+	}
+	task.reset()
+	// A re-driven task may reclaim its own unique key within the window
+	q.mutex.Lock()
+	if entry, ok := q.uniques[task.unique]; ok && entry.id == task.id {
+		delete(q.uniques, task.unique)
+	}
+	q.mutex.Unlock()
+	if err := q.Enqueue(task); err != nil {
+		// Keep the dead letter if the task can't be enqueued
+		_ = store.Put(q.name, letter)
+		return err
+	}
+	return nil
+}
+
+// RedriveAll Re-drives every dead letter of this queue and returns how many
+// were enqueued again.
+func (q *Queue) RedriveAll() (int, error) {
+	letters, err := q.DeadLetters()
+	if err != nil {
+		return 0, err
+	}
+	for i, letter := range letters {
+		if err := q.Redrive(letter.Task.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(letters), nil
+}
@@ -0,0 +1,169 @@
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change A difference between two RawFlow definitions. Op is "+" for an added
+// element, "-" for a removed one and "~" for a changed setting.
+type Change struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Op {
+	case "+":
+		return fmt.Sprintf("+ %s: %s", c.Path, c.To)
+	case "-":
+		return fmt.Sprintf("- %s: %s", c.Path, c.From)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, c.From, c.To)
+}
+
+// Diff Compares two RawFlow definitions by their graph rather than their text,
+// so reordering nodes or edges isn't a change. Changes are returned in a
+// deterministic order.
+func Diff(a, b *RawFlow) []Change {
+	var changes []Change
+	setting := func(path, from, to string) {
+		if from != to {
+			changes = append(changes, Change{Op: "~", Path: path, From: from, To: to})
+		}
+	}
+	setting("key", a.Key, b.Key)
+	setting("namespace", a.Namespace, b.Namespace)
+	setting("first_node", a.FirstNode, b.FirstNode)
+	setting("last_node", a.LastNode, b.LastNode)
+	setting("entry_points", strings.Join(a.EntryPoints, ", "), strings.Join(b.EntryPoints, ", "))
+	setting("run_in_background", strconv.FormatBool(a.RunInBackground), strconv.FormatBool(b.RunInBackground))
+	setting("process_operation_count", strconv.Itoa(a.ProcessOperationCount), strconv.Itoa(b.ProcessOperationCount))
+
+	changes = append(changes, diffSets("nodes", a.Vertices(), b.Vertices())...)
+	changes = append(changes, diffSets("edges", edgeStrings(a), edgeStrings(b))...)
+	changes = append(changes, diffMaps("loops", loopMap(a), loopMap(b))...)
+	changes = append(changes, diffMaps("for_each", forEachMap(a), forEachMap(b))...)
+	changes = append(changes, diffMaps("branches", branchMap(a), branchMap(b))...)
+	changes = append(changes, diffMaps("conditions", conditionMap(a), conditionMap(b))...)
+	changes = append(changes, diffMaps("queues", a.Queues, b.Queues)...)
+	return changes
+}
+
+func diffSets(path string, a, b []string) []Change {
+	inA, inB := make(map[string]bool), make(map[string]bool)
+	for _, v := range a {
+		inA[v] = true
+	}
+	for _, v := range b {
+		inB[v] = true
+	}
+	var changes []Change
+	for _, v := range sortedSet(inA) {
+		if !inB[v] {
+			changes = append(changes, Change{Op: "-", Path: path, From: v})
+		}
+	}
+	for _, v := range sortedSet(inB) {
+		if !inA[v] {
+			changes = append(changes, Change{Op: "+", Path: path, To: v})
+		}
+	}
+	return changes
+}
+
+func diffMaps(path string, a, b map[string]string) []Change {
+	keys := make(map[string]bool)
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	var changes []Change
+	for _, key := range sortedSet(keys) {
+		from, inA := a[key]
+		to, inB := b[key]
+		element := path + "/" + key
+		switch {
+		case !inB:
+			changes = append(changes, Change{Op: "-", Path: element, From: from})
+		case !inA:
+			changes = append(changes, Change{Op: "+", Path: element, To: to})
+		case from != to:
+			changes = append(changes, Change{Op: "~", Path: element, From: from, To: to})
+		}
+	}
+	return changes
+}
+
+func sortedSet(set map[string]bool) []string {
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func edgeStrings(r *RawFlow) []string {
+	var edges []string
+	for _, edge := range r.Edges {
+		edges = append(edges, strings.Join(edge, " -> "))
+	}
+	return edges
+}
+
+// loopMap Describes the children of each loop vertex, in sorted order since
+// they are processed in no particular order.
+func loopMap(r *RawFlow) map[string]string {
+	loops := make(map[string]string)
+	for _, loop := range r.Loops {
+		if len(loop) > 0 {
+			children := append([]string(nil), loop[1:]...)
+			sort.Strings(children)
+			loops[loop[0]] = strings.Join(children, ", ")
+		}
+	}
+	return loops
+}
+
+func forEachMap(r *RawFlow) map[string]string {
+	forEach := make(map[string]string)
+	for _, f := range r.ForEach {
+		forEach[f.InVertex] = strings.Join(f.ChildVertex, ", ")
+	}
+	return forEach
+}
+
+// branchMap Describes each condition of each branch vertex.
+func branchMap(r *RawFlow) map[string]string {
+	branches := make(map[string]string)
+	for _, branch := range r.Branches {
+		for condition, target := range branch.ConditionalNodes {
+			branches[branch.Key+"/"+condition] = target
+		}
+	}
+	return branches
+}
+
+// conditionMap Describes the declarative conditions of each branch vertex, in
+// order since the first matching condition wins.
+func conditionMap(r *RawFlow) map[string]string {
+	conditions := make(map[string]string)
+	for _, branch := range r.Branches {
+		if len(branch.Conditions) == 0 {
+			continue
+		}
+		var descriptions []string
+		for _, condition := range branch.Conditions {
+			descriptions = append(descriptions, condition.String())
+		}
+		conditions[branch.Key] = strings.Join(descriptions, "; ")
+	}
+	return conditions
+}
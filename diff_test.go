@@ -0,0 +1,59 @@
+package flow
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := ParseRaw([]byte(`{
+		"key": "orders",
+		"edges": [["receive", "check"]],
+		"branches": [{"key": "check", "conditional_nodes": {"ok": "ship", "bad": "reject"}}]
+	}`), FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseRaw([]byte("key: orders\nedges:\n  - [ship, notify]\n  - [receive, check]\nbranches:\n  - key: check\n    conditional_nodes:\n      bad: refund\n      ok: ship\n"), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Diff(a, a); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+
+	var got []string
+	for _, change := range Diff(a, b) {
+		got = append(got, change.String())
+	}
+	expected := []string{
+		"- nodes: reject",
+		"+ nodes: notify",
+		"+ nodes: refund",
+		"+ edges: ship -> notify",
+		"~ branches/check/bad: reject -> refund",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestRawFlow_Mermaid(t *testing.T) {
+	raw := &RawFlow{
+		Edges:    [][]string{{"receive", "check"}},
+		Loops:    [][]string{{"ship", "item"}},
+		Branches: []Branch{{Key: "check", ConditionalNodes: map[string]string{"ok": "ship"}}},
+	}
+	out := raw.Mermaid()
+	for _, line := range []string{
+		`n1{"check"}`,
+		"n0 --> n1",
+		"n2 -.->|\"each\"| n3",
+		"n1 -->|\"ok\"| n2",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	// ErrNoEntryPoint Returned when every vertex of a flow has an inbound edge,
+	// so it isn't clear where it starts.
+	ErrNoEntryPoint = errors.New("no entry point")
+
+	// ErrAmbiguousEntryPoints Returned when a flow has several vertices without
+	// an inbound edge and doesn't declare its entry points.
+	ErrAmbiguousEntryPoints = errors.New("ambiguous entry points")
+)
+
+// Roots Returns the vertices without an inbound edge, branch or loop, in order
+// of appearance. The last node doesn't count as a root since it runs after the
+// rest of the flow.
+func (r *RawFlow) Roots() []string {
+	targets := map[string]bool{r.LastNode: true}
+	for _, edge := range r.Edges {
+		if len(edge) == 2 {
+			targets[edge[1]] = true
+		}
+	}
+	for _, loop := range r.Loops {
+		for i := 1; i < len(loop); i++ {
+			targets[loop[i]] = true
+		}
+	}
+	for _, forEach := range r.ForEach {
+		for _, v := range forEach.ChildVertex {
+			targets[v] = true
+		}
+	}
+	for _, branch := range r.Branches {
+		for _, v := range branch.ConditionalNodes {
+			targets[v] = true
+		}
+	}
+	var roots []string
+	for _, v := range r.Vertices() {
+		if !targets[v] {
+			roots = append(roots, v)
+		}
+	}
+	return roots
+}
+
+// Entries Returns the vertices a flow starts with: its declared entry points,
+// else its first node, else its only root. Returns ErrNoEntryPoint or
+// ErrAmbiguousEntryPoints if they can't be determined.
+func (r *RawFlow) Entries() ([]string, error) {
+	if len(r.EntryPoints) > 0 {
+		return r.EntryPoints, nil
+	}
+	if r.FirstNode != "" {
+		return []string{r.FirstNode}, nil
+	}
+	roots := r.Roots()
+	switch len(roots) {
+	case 0:
+		return nil, fmt.Errorf("%w: every vertex has an inbound edge", ErrNoEntryPoint)
+	case 1:
+		return roots, nil
+	}
+	return nil, fmt.Errorf("%w: vertices %s have no inbound edge; declare entry_points or first_node",
+		ErrAmbiguousEntryPoints, strings.Join(roots, ", "))
+}
+
+// EntryPoints Declares the vertices the flow starts with. Several entry points
+// are processed in parallel with the same input.
+func (f *Flow) EntryPoints(vertices ...string) *Flow {
+	f.raw.EntryPoints = append(f.raw.EntryPoints, vertices...)
+	return f
+}
+
+// processEntries Processes the entry points of a flow. The result of several
+// entry points is the input with a JSON array of their payloads, in the order
+// they are declared.
+func processEntries(ctx context.Context, entries []Node, data Data) (Data, error) {
+	if len(entries) == 1 {
+		return entries[0].Process(ctx, data)
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	results := make([]json.RawMessage, len(entries))
+	for i, entry := range entries {
+		i, entry := i, entry
+		// Each entry gets its own copy, as handlers may modify data in place
+		d := data
+		d.Payload = append(Payload(nil), data.Payload...)
+		d.Attachments = append([]Attachment(nil), data.Attachments...)
+		g.Go(func() error {
+			d, err := entry.Process(ctx, d)
+			if err != nil {
+				return err
+			}
+			results[i] = payloadJSON(d.Payload)
+			if results[i] == nil {
+				results[i] = json.RawMessage("null")
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return data, err
+	}
+	payload, err := json.Marshal(results)
+	if err != nil {
+		return data, err
+	}
+	data.Payload = payload
+	return data, nil
+}
@@ -0,0 +1,109 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRawFlow_Entries(t *testing.T) {
+	raw := &RawFlow{
+		Nodes: []string{"audit"},
+		Edges: [][]string{{"receive", "check"}, {"check", "ship"}},
+		Loops: [][]string{{"ship", "pack"}},
+	}
+	if roots := raw.Roots(); !reflect.DeepEqual(roots, []string{"audit", "receive"}) {
+		t.Fatalf("unexpected roots %v", roots)
+	}
+	if _, err := raw.Entries(); !errors.Is(err, ErrAmbiguousEntryPoints) {
+		t.Fatalf("expected ErrAmbiguousEntryPoints, got %v", err)
+	}
+	var errs ValidationErrors
+	if err := raw.Validate(); !errors.As(err, &errs) || errs[0].Path != "/entry_points" {
+		t.Fatalf("expected an entry_points validation error, got %v", err)
+	}
+
+	raw.LastNode = "audit"
+	if entries, err := raw.Entries(); err != nil || !reflect.DeepEqual(entries, []string{"receive"}) {
+		t.Fatalf("expected receive, got %v %v", entries, err)
+	}
+	raw.LastNode = ""
+	raw.EntryPoints = []string{"receive", "audit"}
+	if err := raw.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlow_EntryPoints(t *testing.T) {
+	suffix := func(s string) Handler {
+		return func(ctx context.Context, d Data) (Data, error) {
+			d.Payload = Payload(string(d.Payload) + s)
+			return d, nil
+		}
+	}
+	f := New().WithRegistry(NewRegistry()).
+		AddNode("a", suffix("a")).
+		AddNode("b", suffix("b")).
+		AddNode("c", suffix("c")).
+		Edge("b", "c")
+	if f.Build(); !errors.Is(f.Error, ErrAmbiguousEntryPoints) {
+		t.Fatalf("expected ErrAmbiguousEntryPoints, got %v", f.Error)
+	}
+
+	f = New().WithRegistry(NewRegistry()).
+		AddNode("a", suffix("a")).
+		AddNode("b", suffix("b")).
+		AddNode("c", suffix("c")).
+		Edge("b", "c").
+		EntryPoints("b", "a").
+		Build()
+	if f.Error != nil {
+		t.Fatal(f.Error)
+	}
+	for i := 0; i < 5; i++ {
+		result, err := f.Process(context.Background(), Data{Payload: Payload("x")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := `["xbc","xa"]`; string(result.Payload) != expected {
+			t.Fatalf("expected %s, got %s", expected, result.Payload)
+		}
+	}
+
+	single := New().WithRegistry(NewRegistry()).AddNode("only", suffix("!")).Build()
+	result, err := single.Process(context.Background(), Data{Payload: Payload("x")})
+	if err != nil || string(result.Payload) != "x!" {
+		t.Fatalf("expected the only vertex to run, got %s %v", result.Payload, err)
+	}
+}
+
+func TestFlow_EntryPointsInPlace(t *testing.T) {
+	overwrite := func(b byte) Handler {
+		return func(ctx context.Context, d Data) (Data, error) {
+			for i := range d.Payload {
+				d.Payload[i] = b
+			}
+			return d, nil
+		}
+	}
+	f := New().WithRegistry(NewRegistry()).
+		AddNode("a", overwrite('a')).
+		AddNode("b", overwrite('b')).
+		EntryPoints("a", "b").
+		Build()
+	if f.Error != nil {
+		t.Fatal(f.Error)
+	}
+	input := Data{Payload: Payload("xx")}
+	result, err := f.Process(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `["aa","bb"]`; string(result.Payload) != expected {
+		t.Fatalf("expected %s, got %s", expected, result.Payload)
+	}
+	if string(input.Payload) != "xx" {
+		t.Fatalf("expected the input to be left unchanged, got %s", input.Payload)
+	}
+}
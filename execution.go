@@ -0,0 +1,105 @@
+package flow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Execution statuses.
+const (
+	StatusPending    = "PENDING"
+	StatusProcessing = "PROCESSING"
+	StatusCompleted  = "COMPLETED"
+	StatusFailed     = "FAILED"
+)
+
+// Execution Records a single run of a flow. The flow version is pinned when the
+// execution is created, so retries run on the same graph even if a newer
+// version has been registered since.
+type Execution struct {
+	ID         string    `json:"id"`
+	Namespace  string    `json:"namespace"`
+	Flow       string    `json:"flow"`
+	Version    int       `json:"version"`
+	Status     string    `json:"status"`
+	Input      Data      `json:"input"`
+	Result     Data      `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	Attempts   int       `json:"attempts"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewExecution Creates a pending execution pinned to the latest active version
+// of a flow.
+func (r *Registry) NewExecution(namespace, key string, data Data) (*Execution, error) {
+	return r.newExecution(namespace, key, 0, data)
+}
+
+func (r *Registry) newExecution(namespace, key string, version int, data Data) (*Execution, error) {
+	f, err := r.GetVersion(namespace, key, version)
+	if err != nil {
+		return nil, err
+	}
+	data.Flow = key
+	data.FlowVersion = f.Version
+	return &Execution{
+		ID:        newID(),
+		Namespace: namespaceOf(namespace),
+		Flow:      key,
+		Version:   f.Version,
+		Status:    StatusPending,
+		Input:     data,
+	}, nil
+}
+
+// Execute Creates an execution pinned to the latest active version of a flow
+// and runs it. The execution is returned even if the flow failed.
+func (r *Registry) Execute(ctx context.Context, namespace, key string, data Data) (*Execution, error) {
+	exec, err := r.NewExecution(namespace, key, data)
+	if err != nil {
+		return nil, err
+	}
+	return exec, r.Run(ctx, exec)
+}
+
+// Run Runs or re-runs an execution on the flow version it is pinned to.
+func (r *Registry) Run(ctx context.Context, exec *Execution) error {
+	f, err := r.GetVersion(exec.Namespace, exec.Flow, exec.Version)
+	if err != nil {
+		exec.Status = StatusFailed
+		exec.Error = err.Error()
+		return err
+	}
+	exec.Attempts++
+	exec.Status = StatusProcessing
+	exec.Error = ""
+	exec.StartedAt = Now()
+	exec.FinishedAt = time.Time{}
+	result, err := f.Process(ctx, exec.Input)
+	exec.FinishedAt = Now()
+	exec.Result = result
+	if err != nil {
+		exec.Status = StatusFailed
+		exec.Error = err.Error()
+		return err
+	}
+	exec.Status = StatusCompleted
+	return nil
+}
+
+// ForData Resolves the flow a Data record belongs to, using its Flow key and
+// pinned FlowVersion.
+func (r *Registry) ForData(namespace string, data Data) (*Flow, error) {
+	return r.GetVersion(namespace, data.Flow, data.FlowVersion)
+}
@@ -0,0 +1,185 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ExplainStep A vertex which would be processed, in the order Process would
+// reach it.
+type ExplainStep struct {
+	Vertex string `json:"vertex"`
+	Type   string `json:"type"`
+
+	// Depth Nesting of the vertex: loop children are one deeper than their
+	// loop vertex.
+	Depth int `json:"depth"`
+
+	// Loop Children processed for each element of a loop vertex's response.
+	Loop []string `json:"loop,omitempty"`
+
+	// Branches Possible targets of a branch vertex, by condition.
+	Branches map[string]string `json:"branches,omitempty"`
+
+	// Status Condition the branch takes, if it could be determined without
+	// running the handler.
+	Status string `json:"status,omitempty"`
+
+	// Taken Vertex the branch continues with, if any.
+	Taken string `json:"taken,omitempty"`
+}
+
+// Explanation Execution plan of a flow for a sample input. The steps of
+// several entry points, which run in parallel, are listed one after another.
+type Explanation struct {
+	FirstNode   string        `json:"first_node"`
+	EntryPoints []string      `json:"entry_points"`
+	LastNode    string        `json:"last_node,omitempty"`
+	Steps       []ExplainStep `json:"steps"`
+}
+
+// Sequence Returns the vertices of the steps in order.
+func (e *Explanation) Sequence() []string {
+	sequence := make([]string, 0, len(e.Steps))
+	for _, step := range e.Steps {
+		sequence = append(sequence, step.Vertex)
+	}
+	return sequence
+}
+
+func (e *Explanation) String() string {
+	var b strings.Builder
+	for _, step := range e.Steps {
+		fmt.Fprintf(&b, "%s%s (%s)", strings.Repeat("  ", step.Depth), step.Vertex, step.Type)
+		if len(step.Loop) > 0 {
+			fmt.Fprintf(&b, " for each: %s", strings.Join(step.Loop, ", "))
+		}
+		if len(step.Branches) > 0 {
+			var targets []string
+			for _, condition := range sortedKeys(step.Branches) {
+				targets = append(targets, condition+" -> "+step.Branches[condition])
+			}
+			fmt.Fprintf(&b, " branches: %s", strings.Join(targets, ", "))
+			if step.Taken != "" {
+				fmt.Fprintf(&b, "; takes %s", step.Status)
+			} else {
+				b.WriteString("; undetermined")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Explain Walks the built graph in the order Process would, as if every handler
+// passed its data through unchanged, and returns the vertices it would reach.
+// Branches take the status of the sample data or else of their first matching
+// condition; a branch whose status can't be determined lists its possible
+// targets without following them. No handlers are invoked.
+func (f *Flow) Explain(data Data) (*Explanation, error) {
+	if f.Error != nil {
+		return nil, f.Error
+	}
+	if len(f.entries) == 0 {
+		if t := f.Build(); t.Error != nil {
+			return nil, t.Error
+		}
+	}
+	if len(f.entries) == 0 {
+		return nil, errors.New("no edges defined")
+	}
+	e := &Explanation{FirstNode: f.entries[0].GetKey()}
+	w := &explainer{explanation: e, visiting: make(map[string]bool)}
+	for _, entry := range f.entries {
+		e.EntryPoints = append(e.EntryPoints, entry.GetKey())
+		if err := w.walk(entry, data, 0); err != nil {
+			return nil, err
+		}
+	}
+	if f.lastNode != nil {
+		e.LastNode = f.lastNode.GetKey()
+		if err := w.walk(f.lastNode, data, 0); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// explainer Collects the steps of an Explanation.
+type explainer struct {
+	explanation *Explanation
+	visiting    map[string]bool
+}
+
+func (w *explainer) walk(n Node, data Data, depth int) error {
+	key := n.GetKey()
+	if w.visiting[key] {
+		return fmt.Errorf("cycle detected at vertex '%s'", key)
+	}
+	step := ExplainStep{Vertex: key, Type: n.GetType(), Depth: depth}
+	v, ok := n.(*Vertex)
+	if !ok {
+		w.explanation.Steps = append(w.explanation.Steps, step)
+		return nil
+	}
+	w.visiting[key] = true
+	defer delete(w.visiting, key)
+
+	var taken Node
+	if len(v.branches) > 0 || len(v.conditions) > 0 {
+		step.Branches = make(map[string]string, len(v.branches))
+		for condition, target := range v.branches {
+			step.Branches[condition] = target.GetKey()
+		}
+		if len(data.Payload) > 0 || data.Status != "" {
+			status, err := v.branchStatus(data)
+			if err != nil {
+				return err
+			}
+			if target, ok := v.branches[status]; ok {
+				step.Status, step.Taken, taken = status, target.GetKey(), target
+			}
+		}
+	}
+	for _, child := range sortedNodes(v.loops) {
+		step.Loop = append(step.Loop, child.GetKey())
+	}
+	w.explanation.Steps = append(w.explanation.Steps, step)
+
+	for _, child := range sortedNodes(v.loops) {
+		// The elements of a loop come from its handler, so branches of its
+		// children are left undetermined.
+		if err := w.walk(child, Data{}, depth+1); err != nil {
+			return err
+		}
+	}
+	if taken != nil {
+		if err := w.walk(taken, data, depth); err != nil {
+			return err
+		}
+	}
+	for _, edge := range sortedNodes(v.edges) {
+		if err := w.walk(edge, data, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedNodes Returns the nodes of a map ordered by key.
+func sortedNodes(nodes map[string]Node) []Node {
+	keys := make([]string, 0, len(nodes))
+	for key, n := range nodes {
+		if n != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	sorted := make([]Node, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, nodes[key])
+	}
+	return sorted
+}
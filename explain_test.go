@@ -0,0 +1,136 @@
+package flow
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFlow_Explain(t *testing.T) {
+	var calls int32
+	handler := func(ctx context.Context, d Data) (Data, error) {
+		atomic.AddInt32(&calls, 1)
+		return d, nil
+	}
+	raw := []byte(`
+edges:
+  - [receive, check]
+  - [ship, notify]
+branches:
+  - key: check
+    conditional_nodes:
+      large: review
+      small: ship
+    conditions:
+      - status: large
+        field: order.total
+        operator: gte
+        value: 1000
+      - status: small
+        field: order.total
+        operator: exists
+`)
+	if err := ValidateRaw(raw); err != nil {
+		t.Fatal(err)
+	}
+	f := New(raw).WithRegistry(NewRegistry())
+	for _, v := range []string{"receive", "review", "ship", "notify"} {
+		f.AddNode(v, handler)
+	}
+	f.Build()
+
+	explanation, err := f.Explain(Data{Payload: Payload(`{"order": {"total": 25}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no handler calls, got %d", calls)
+	}
+	expected := []string{"receive", "check", "ship", "notify"}
+	if got := explanation.Sequence(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	step := explanation.Steps[1]
+	if step.Status != "small" || step.Taken != "ship" || step.Branches["large"] != "review" {
+		t.Errorf("unexpected branch step %+v", step)
+	}
+
+	explanation, err = f.Explain(Data{Payload: Payload(`{"order": {"total": 2500}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := explanation.Sequence(); !reflect.DeepEqual(got, []string{"receive", "check", "review"}) {
+		t.Errorf("unexpected sequence %v", got)
+	}
+	explanation, err = f.Explain(Data{Payload: Payload(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step := explanation.Steps[1]; step.Taken != "" || len(explanation.Steps) != 2 {
+		t.Errorf("expected an undetermined branch, got %v", explanation)
+	}
+
+	result, err := f.Process(context.Background(), Data{Payload: Payload(`{"order": {"total": 2500}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || string(result.Payload) != `{"order": {"total": 2500}}` {
+		t.Errorf("expected receive and review to run, got %d calls", calls)
+	}
+}
+
+func TestCondition_Match(t *testing.T) {
+	doc := map[string]interface{}{
+		"name": "ada",
+		"tags": []interface{}{"vip"},
+		"age":  float64(36),
+	}
+	cases := []struct {
+		condition Condition
+		match     bool
+	}{
+		{Condition{Field: "name", Value: "ada"}, true},
+		{Condition{Field: "name", Operator: "ne", Value: "ada"}, false},
+		{Condition{Field: "age", Operator: "lt", Value: 40}, true},
+		{Condition{Field: "age", Operator: "gt", Value: "40"}, false},
+		{Condition{Field: "name", Operator: "in", Value: []string{"bob", "ada"}}, true},
+		{Condition{Field: "tags", Operator: "contains", Value: "vip"}, true},
+		{Condition{Field: "email", Operator: "exists"}, false},
+		{Condition{Field: "email", Operator: "exists", Value: false}, true},
+	}
+	for _, c := range cases {
+		match, err := c.condition.Match(doc)
+		if err != nil {
+			t.Errorf("%s: %v", c.condition, err)
+		} else if match != c.match {
+			t.Errorf("%s: expected %v", c.condition, c.match)
+		}
+	}
+}
+
+func TestFlow_ConditionsWithoutNodes(t *testing.T) {
+	f := New().WithRegistry(NewRegistry()).
+		AddNode("receive", echo).
+		Edge("receive", "check").
+		Conditions("check", Condition{Status: "large", Field: "total", Operator: "gte", Value: 1000})
+	if f.Build().Error == nil {
+		t.Fatal("expected a branch without conditional nodes to be rejected")
+	}
+	if err := f.Raw().Validate(); err == nil {
+		t.Fatal("expected validation to fail")
+	}
+
+	f = New().WithRegistry(NewRegistry()).
+		AddNode("receive", echo).
+		AddNode("review", echo).
+		Edge("receive", "check").
+		ConditionalNode("check", map[string]string{"large": "review"}).
+		Conditions("check", Condition{Status: "large", Field: "total", Operator: "gte", Value: 1000})
+	if f.Build().Error != nil {
+		t.Fatal(f.Error)
+	}
+	if _, err := f.Process(context.Background(), Data{Payload: Payload(`{"total": 2500}`)}); err != nil {
+		t.Fatal(err)
+	}
+}
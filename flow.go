@@ -2,16 +2,24 @@ package flow
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 )
 
 type Flow struct {
 	Key       string `json:"key"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
 	Error     error  `json:"error"`
 	Status    string `json:"status"`
-	firstNode Node
+	registry  *Registry
+	runner    *BackgroundRunner
+	entries   []Node
+	plan      *Plan
+	// compiling Serializes the lazy build of a flow processed before it was
+	// built.
+	compiling sync.Mutex
 	lastNode  Node
 	rawNodes  map[string]Handler
 	nodes     map[string]Node
@@ -21,25 +29,30 @@ type Flow struct {
 }
 
 type RawFlow struct {
-	RunInBackground       bool       `json:"run_in_background"`
-	ProcessOperationCount int        `json:"process_operation_count"`
-	FirstNode             string     `json:"first_node,omitempty"`
-	LastNode              string     `json:"last_node,omitempty"`
-	Nodes                 []string   `json:"nodes,omitempty"`
-	Loops                 [][]string `json:"loops,omitempty"`
-	ForEach               []ForEach  `json:"for_each,omitempty"`
-	Branches              []Branch   `json:"branches,omitempty"`
-	Edges                 [][]string `json:"edges,omitempty"`
+	Key                   string            `json:"key,omitempty" yaml:"key,omitempty" toml:"key,omitempty"`
+	Namespace             string            `json:"namespace,omitempty" yaml:"namespace,omitempty" toml:"namespace,omitempty"`
+	RunInBackground       bool              `json:"run_in_background" yaml:"run_in_background" toml:"run_in_background"`
+	ProcessOperationCount int               `json:"process_operation_count" yaml:"process_operation_count" toml:"process_operation_count"`
+	FirstNode             string            `json:"first_node,omitempty" yaml:"first_node,omitempty" toml:"first_node,omitempty"`
+	EntryPoints           []string          `json:"entry_points,omitempty" yaml:"entry_points,omitempty" toml:"entry_points,omitempty"`
+	LastNode              string            `json:"last_node,omitempty" yaml:"last_node,omitempty" toml:"last_node,omitempty"`
+	Nodes                 []string          `json:"nodes,omitempty" yaml:"nodes,omitempty" toml:"nodes,omitempty"`
+	Loops                 [][]string        `json:"loops,omitempty" yaml:"loops,omitempty" toml:"loops,omitempty"`
+	ForEach               []ForEach         `json:"for_each,omitempty" yaml:"for_each,omitempty" toml:"for_each,omitempty"`
+	Branches              []Branch          `json:"branches,omitempty" yaml:"branches,omitempty" toml:"branches,omitempty"`
+	Edges                 [][]string        `json:"edges,omitempty" yaml:"edges,omitempty" toml:"edges,omitempty"`
+	Queues                map[string]string `json:"queues,omitempty" yaml:"queues,omitempty" toml:"queues,omitempty"`
 }
 
 type Branch struct {
-	Key              string            `json:"key"`
-	ConditionalNodes map[string]string `json:"conditional_nodes"`
+	Key              string            `json:"key" yaml:"key" toml:"key"`
+	ConditionalNodes map[string]string `json:"conditional_nodes" yaml:"conditional_nodes" toml:"conditional_nodes"`
+	Conditions       []Condition       `json:"conditions,omitempty" yaml:"conditions,omitempty" toml:"conditions,omitempty"`
 }
 
 type ForEach struct {
-	InVertex    string   `json:"in_vertex"`
-	ChildVertex []string `json:"child_vertex"`
+	InVertex    string   `json:"in_vertex" yaml:"in_vertex" toml:"in_vertex"`
+	ChildVertex []string `json:"child_vertex" yaml:"child_vertex" toml:"child_vertex"`
 }
 
 func New(raw ...Payload) *Flow {
@@ -54,17 +67,21 @@ func New(raw ...Payload) *Flow {
 	if len(raw) == 0 {
 		return f
 	}
-	err := json.Unmarshal(raw[0], &rawFlow)
+	rawFlow, err := ParseRaw(raw[0], "")
 	if err != nil {
 		f.Error = err
 		return f
 	}
 	f.raw = rawFlow
+	f.Key = rawFlow.Key
+	f.Namespace = rawFlow.Namespace
 	return f
 }
 
 func NewRaw(flow *RawFlow) *Flow {
 	return &Flow{
+		Key:       flow.Key,
+		Namespace: flow.Namespace,
 		nodes:     make(map[string]Node),
 		inVertex:  make(map[string]bool),
 		outVertex: make(map[string]bool),
@@ -90,6 +107,12 @@ func (f *Flow) Edge(inVertex, outVertex string) *Flow {
 }
 
 func (f *Flow) ConditionalNode(vertex string, conditions map[string]string) *Flow {
+	for i, branch := range f.raw.Branches {
+		if branch.Key == vertex {
+			f.raw.Branches[i].ConditionalNodes = conditions
+			return f
+		}
+	}
 	branch := Branch{
 		Key:              vertex,
 		ConditionalNodes: conditions,
@@ -98,6 +121,25 @@ func (f *Flow) ConditionalNode(vertex string, conditions map[string]string) *Flo
 	return f
 }
 
+// Conditions Adds declarative conditions to the branch of a vertex, which are
+// evaluated in order when its handler doesn't set a status. A branch with
+// conditions doesn't need a handler, but needs the conditional nodes of their
+// statuses, see ConditionalNode.
+func (f *Flow) Conditions(vertex string, conditions ...Condition) *Flow {
+	for i, branch := range f.raw.Branches {
+		if branch.Key == vertex {
+			f.raw.Branches[i].Conditions = append(branch.Conditions, conditions...)
+			return f
+		}
+	}
+	f.raw.Branches = append(f.raw.Branches, Branch{
+		Key:              vertex,
+		ConditionalNodes: make(map[string]string),
+		Conditions:       conditions,
+	})
+	return f
+}
+
 func (f *Flow) Loop(inVertex string, childVertex ...string) *Flow {
 	v := []string{inVertex}
 	v = append(v, childVertex...)
@@ -114,47 +156,24 @@ func (f *Flow) ForEach(inVertex string, childVertex ...string) *Flow {
 	return f
 }
 
-func (f *Flow) Process(ctx context.Context, data Data) (Data, error) {
-	if f.Error != nil {
-		return data, f.Error
-	}
-	f.Status = "PROCESSING"
-	if f.firstNode != nil {
-		d, err := f.firstNode.Process(ctx, data)
-		if err != nil {
-			return d, err
-		}
-		if f.lastNode != nil {
-			return f.lastNode.Process(ctx, d)
-		}
-		return d, nil
-	}
-	t := f.Build()
-	if t.Error != nil {
-		return data, t.Error
+// OnQueue Routes a vertex to a named queue when the flow runs on a StepRunner.
+func (f *Flow) OnQueue(vertex, queue string) *Flow {
+	if f.raw.Queues == nil {
+		f.raw.Queues = make(map[string]string)
 	}
-	if f.firstNode != nil {
-		d, err := f.firstNode.Process(ctx, data)
-		if err != nil {
-			return d, err
-		}
-		if f.lastNode != nil {
-			return f.lastNode.Process(ctx, d)
-		}
-		return d, nil
+	f.raw.Queues[vertex] = queue
+	return f
+}
+
+func (f *Flow) Process(ctx context.Context, data Data) (Data, error) {
+	plan, err := f.Compile()
+	if err != nil {
+		return data, err
 	}
-	for _, n := range f.nodes {
-		f.firstNode = n
-		d, err := f.firstNode.Process(ctx, data)
-		if err != nil {
-			return d, err
-		}
-		if f.lastNode != nil {
-			return f.lastNode.Process(ctx, d)
-		}
-		return d, nil
+	if d, ok, err := f.background(ctx, data); ok {
+		return d, err
 	}
-	return data, errors.New("no edges defined")
+	return plan.Process(ctx, data)
 }
 
 func (f *Flow) GetType() string {
@@ -174,8 +193,20 @@ func (f *Flow) WithRaw(raw *RawFlow) *Flow {
 	return f
 }
 
+// WithRegistry Sets the registry the flow is added to when built. Defaults to
+// DefaultRegistry.
+func (f *Flow) WithRegistry(registry *Registry) *Flow {
+	f.registry = registry
+	return f
+}
+
+// GetNodeHandler Returns the handler added for node, falling back to the
+// handler registered under the same name with RegisterHandler.
 func (f *Flow) GetNodeHandler(node string) Handler {
-	return f.rawNodes[node]
+	if handler, ok := f.rawNodes[node]; ok {
+		return handler
+	}
+	return LookupHandler(node)
 }
 
 func (f *Flow) Build() *Flow {
@@ -186,17 +217,22 @@ func (f *Flow) Build() *Flow {
 	if len(f.raw.Edges) == 0 {
 		noEdges = true
 	}
-	for _, edge := range f.raw.Edges {
+	for i, edge := range f.raw.Edges {
+		if len(edge) != 2 {
+			f.Error = fmt.Errorf("edge %d must have exactly 2 vertices, got %d", i, len(edge))
+			return f
+		}
 		f.addNode(edge[0])
 		f.addNode(edge[1])
 	}
-	if f.raw.FirstNode != "" {
-		f.firstNode = f.nodes[f.raw.FirstNode]
-	}
 	if f.raw.LastNode != "" {
 		f.lastNode = f.nodes[f.raw.LastNode]
 	}
-	for _, loop := range f.raw.Loops {
+	for i, loop := range f.raw.Loops {
+		if len(loop) == 0 {
+			f.Error = fmt.Errorf("loop %d must have a loop vertex", i)
+			return f
+		}
 		loopHandler := f.GetNodeHandler(loop[0])
 		childVertexes := loop[1:]
 		for _, v := range childVertexes {
@@ -207,12 +243,19 @@ func (f *Flow) Build() *Flow {
 		}
 	}
 	for _, branch := range f.raw.Branches {
+		if len(branch.ConditionalNodes) == 0 {
+			f.Error = fmt.Errorf("branch '%s' has no conditional nodes", branch.Key)
+			return f
+		}
 		branchHandler := f.GetNodeHandler(branch.Key)
+		if branchHandler == nil && len(branch.Conditions) > 0 {
+			branchHandler = passThrough
+		}
 		if branchHandler == nil {
 			f.Error = errors.New(fmt.Sprintf("No branch handler defined for key '%s'", branch.Key))
 			return f
 		}
-		f.conditionalNode(branch.Key, branchHandler, branch.ConditionalNodes)
+		f.conditionalNode(branch.Key, branchHandler, branch.ConditionalNodes, branch.Conditions)
 	}
 
 	for _, edge := range f.raw.Edges {
@@ -221,10 +264,43 @@ func (f *Flow) Build() *Flow {
 	if noEdges && noNodes {
 		f.Error = errors.New("no vertex or edges are defined")
 	}
-	Add(f.Key, f)
+	if f.Error == nil {
+		f.resolveEntries()
+	}
+	if f.Error == nil {
+		f.plan, f.Error = f.compile()
+	}
+	if f.Error == nil && f.Key != "" {
+		registry := f.registry
+		if registry == nil {
+			registry = DefaultRegistry
+		}
+		if err := registry.Register(f.Namespace, f.Key, f); err != nil {
+			f.Error = err
+		}
+	}
 	return f
 }
 
+// resolveEntries Resolves the entry points of the flow to its built nodes.
+func (f *Flow) resolveEntries() {
+	keys, err := f.raw.Entries()
+	if err != nil {
+		f.Error = err
+		return
+	}
+	entries := make([]Node, 0, len(keys))
+	for _, key := range keys {
+		n, ok := f.nodes[key]
+		if !ok {
+			f.Error = fmt.Errorf("no handler defined for entry vertex '%s'", key)
+			return
+		}
+		entries = append(entries, n)
+	}
+	f.entries = entries
+}
+
 func (f *Flow) OperationCountByType(optType string) int {
 	return f.raw.ProcessOperationCount
 }
@@ -240,7 +316,7 @@ func (f *Flow) addNode(node string) {
 	}
 }
 
-func (f *Flow) conditionalNode(vertex string, handler Handler, conditions map[string]string) *Flow {
+func (f *Flow) conditionalNode(vertex string, handler Handler, conditions map[string]string, declarative []Condition) *Flow {
 	branches := make(map[string]Node)
 	if n, ok := f.nodes[vertex]; ok {
 		node := n.(*Vertex)
@@ -251,6 +327,7 @@ func (f *Flow) conditionalNode(vertex string, handler Handler, conditions map[st
 			}
 		}
 		node.branches = branches
+		node.conditions = declarative
 		f.nodes[vertex] = node
 	} else {
 		node := &Vertex{
@@ -258,6 +335,7 @@ func (f *Flow) conditionalNode(vertex string, handler Handler, conditions map[st
 			Type:             "Branch",
 			handler:          handler,
 			ConditionalNodes: conditions,
+			conditions:       declarative,
 		}
 		for condition, nodeKey := range conditions {
 			f.outVertex[nodeKey] = true
@@ -300,11 +378,6 @@ func (f *Flow) edge(inVertex, outVertex string) *Flow {
 	}
 	f.inVertex[inVertex] = true
 	f.outVertex[outVertex] = true
-	inOk := f.inVertex[inVertex]
-	outOk := f.outVertex[inVertex]
-	if f.firstNode == nil && inOk && !outOk {
-		f.firstNode = f.nodes[inVertex]
-	}
 	if okInNode && okOutNode {
 		inNode.AddEdge(outNode)
 	}
@@ -327,17 +400,3 @@ func (f *Flow) loop(inVertex string, inHandler Handler, childVertex ...string) *
 	f.nodes[inVertex] = loop
 	return f
 }
-
-var flowList = map[string]*Flow{}
-
-func Add(key string, flow *Flow) {
-	flowList[key] = flow
-}
-
-func Get(key string) *Flow {
-	return flowList[key]
-}
-
-func All() map[string]*Flow {
-	return flowList
-}
@@ -0,0 +1,200 @@
+package flow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format Identifies the encoding of a RawFlow document.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// ErrUnknownFormat Returned when a RawFlow document is in an unsupported format.
+var ErrUnknownFormat = errors.New("unknown flow definition format")
+
+// ParseError Reports a RawFlow document which couldn't be decoded. Line and
+// Column are 1-based and zero when the decoder didn't report a position.
+type ParseError struct {
+	Format Format
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %v", e.Format, e.Err)
+	}
+	return fmt.Sprintf("%s: line %d, column %d: %v", e.Format, e.Line, e.Column, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+var tomlKeyValue = regexp.MustCompile(`^[A-Za-z0-9_."'-]+\s*=`)
+
+// DetectFormat Guesses the format of a RawFlow document from its content.
+// Documents starting with '{' are JSON, unless they are only valid as flow
+// style YAML; documents starting with a TOML table header or key/value pair
+// are TOML; everything else is treated as YAML.
+func DetectFormat(raw []byte) Format {
+	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "{"):
+			if !json.Valid(raw) && yaml.Unmarshal(raw, new(yaml.Node)) == nil {
+				return FormatYAML
+			}
+			return FormatJSON
+		case strings.HasPrefix(line, "["), tomlKeyValue.MatchString(line):
+			return FormatTOML
+		}
+		return FormatYAML
+	}
+	return FormatJSON
+}
+
+// FormatFromExt Returns the format implied by a file extension.
+func FormatFromExt(path string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, true
+	case ".yaml", ".yml":
+		return FormatYAML, true
+	case ".toml":
+		return FormatTOML, true
+	}
+	return "", false
+}
+
+// ParseRaw Decodes a RawFlow document. If format is empty it is detected from
+// the content. Decoding errors are returned as *ParseError.
+func ParseRaw(raw []byte, format Format) (*RawFlow, error) {
+	if format == "" {
+		format = DetectFormat(raw)
+	}
+	rawFlow := &RawFlow{}
+	switch format {
+	case FormatJSON:
+		if err := json.Unmarshal(raw, rawFlow); err != nil {
+			return nil, jsonParseError(raw, err)
+		}
+	case FormatYAML:
+		var doc yaml.Node
+		if err := yaml.Unmarshal(raw, &doc); err != nil {
+			return nil, yamlParseError(nil, err)
+		}
+		if err := doc.Decode(rawFlow); err != nil {
+			return nil, yamlParseError(&doc, err)
+		}
+	case FormatTOML:
+		if _, err := toml.Decode(string(raw), rawFlow); err != nil {
+			return nil, tomlParseError(err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownFormat, format)
+	}
+	return rawFlow, nil
+}
+
+// NewWithFormat Creates a flow from a RawFlow document in the given format.
+func NewWithFormat(format Format, raw Payload) *Flow {
+	rawFlow, err := ParseRaw(raw, format)
+	if err != nil {
+		f := New()
+		f.Error = err
+		return f
+	}
+	return NewRaw(rawFlow)
+}
+
+func lineColumn(raw []byte, offset int64) (int, int) {
+	if offset > int64(len(raw)) {
+		offset = int64(len(raw))
+	}
+	line, column := 1, 1
+	for _, b := range raw[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+func jsonParseError(raw []byte, err error) error {
+	parseErr := &ParseError{Format: FormatJSON, Err: err}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		parseErr.Line, parseErr.Column = lineColumn(raw, syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		parseErr.Line, parseErr.Column = lineColumn(raw, typeErr.Offset)
+	}
+	return parseErr
+}
+
+var errorLine = regexp.MustCompile(`line (\d+)`)
+
+func yamlParseError(doc *yaml.Node, err error) error {
+	parseErr := &ParseError{Format: FormatYAML, Err: err}
+	if m := errorLine.FindStringSubmatch(err.Error()); m != nil {
+		parseErr.Line, _ = strconv.Atoi(m[1])
+		parseErr.Column = yamlColumn(doc, parseErr.Line)
+	}
+	return parseErr
+}
+
+// yamlColumn Returns the column of the last node on the given line, which is
+// the offending value in a key/value pair, since yaml.v3 only reports lines.
+func yamlColumn(node *yaml.Node, line int) int {
+	if node == nil {
+		return 0
+	}
+	column := 0
+	if node.Line == line {
+		column = node.Column
+	}
+	for _, child := range node.Content {
+		if c := yamlColumn(child, line); c != 0 {
+			column = c
+		}
+	}
+	return column
+}
+
+func tomlParseError(err error) error {
+	parseErr := &ParseError{Format: FormatTOML, Err: err}
+	var tomlErr toml.ParseError
+	if errors.As(err, &tomlErr) {
+		parseErr.Line, parseErr.Column = tomlErr.Position.Line, tomlErr.Position.Col
+		parseErr.Err = errors.New(tomlErr.Message)
+	} else if m := errorLine.FindStringSubmatch(err.Error()); m != nil {
+		parseErr.Line, _ = strconv.Atoi(m[1])
+	}
+	return parseErr
+}
@@ -0,0 +1,111 @@
+package flow
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]Format{
+		`{"edges": [["a", "b"]]}`:             FormatJSON,
+		"\xef\xbb\xbf\n  {\"key\": \"a\"}":    FormatJSON,
+		"":                                    FormatJSON,
+		`{"edges": [["a", "b"]}`:              FormatJSON,
+		"{key: orders, edges: [[a, b]]}\n":    FormatYAML,
+		"# comment\nedges:\n  - [a, b]\n":     FormatYAML,
+		"- a\n":                               FormatYAML,
+		"key = \"orders\"\n":                  FormatTOML,
+		"# comment\n[[for_each]]\n":           FormatTOML,
+		"first_node=\"a\"\nedges=[[\"a\"]]\n": FormatTOML,
+	}
+	for doc, expected := range cases {
+		if format := DetectFormat([]byte(doc)); format != expected {
+			t.Errorf("%q: expected %s, got %s", doc, expected, format)
+		}
+	}
+}
+
+func TestFormatFromExt(t *testing.T) {
+	cases := []struct {
+		path   string
+		format Format
+		ok     bool
+	}{
+		{"flows/orders.json", FormatJSON, true},
+		{"orders.YAML", FormatYAML, true},
+		{"orders.yml", FormatYAML, true},
+		{"orders.toml", FormatTOML, true},
+		{"orders.txt", "", false},
+		{"orders", "", false},
+	}
+	for _, c := range cases {
+		if format, ok := FormatFromExt(c.path); format != c.format || ok != c.ok {
+			t.Errorf("%s: expected %s %v, got %s %v", c.path, c.format, c.ok, format, ok)
+		}
+	}
+}
+
+func TestParseRaw(t *testing.T) {
+	expected := &RawFlow{
+		Key:       "orders",
+		FirstNode: "receive",
+		Edges:     [][]string{{"receive", "ship"}},
+		Branches:  []Branch{{Key: "ship", ConditionalNodes: map[string]string{"late": "notify"}}},
+	}
+	docs := map[Format]string{
+		FormatJSON: `{"key": "orders", "first_node": "receive", "edges": [["receive", "ship"]],
+			"branches": [{"key": "ship", "conditional_nodes": {"late": "notify"}}]}`,
+		FormatYAML: "key: orders\nfirst_node: receive\nedges:\n  - [receive, ship]\nbranches:\n  - key: ship\n    conditional_nodes:\n      late: notify\n",
+		FormatTOML: "key = \"orders\"\nfirst_node = \"receive\"\nedges = [[\"receive\", \"ship\"]]\n\n[[branches]]\nkey = \"ship\"\n[branches.conditional_nodes]\nlate = \"notify\"\n",
+	}
+	for format, doc := range docs {
+		for _, f := range []Format{format, ""} {
+			raw, err := ParseRaw([]byte(doc), f)
+			if err != nil {
+				t.Errorf("%s: %v", format, err)
+			} else if !reflect.DeepEqual(raw, expected) {
+				t.Errorf("%s: expected %+v, got %+v", format, expected, raw)
+			}
+		}
+	}
+	if _, err := ParseRaw([]byte("{}"), "xml"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestParseRaw_Errors(t *testing.T) {
+	cases := []struct {
+		format       Format
+		doc          string
+		line, column int
+	}{
+		{FormatJSON, "{\n  \"key\": \"orders\",\n  \"edges\": [[\"a\", \"b\"],]\n}", 3, 25},
+		{FormatJSON, "{\n  \"key\": \"orders\",\n  \"process_operation_count\": \"x\"\n}", 3, 33},
+		// yaml.v3 only reports the line of syntax errors
+		{FormatYAML, "key: orders\nedges:\n  - [a, b\nnodes: [c]\n", 2, 0},
+		{FormatYAML, "key: orders\nprocess_operation_count: many\n", 2, 26},
+		{FormatTOML, "key = \"orders\"\nedges = [[\"a\", \"b\"]\n", 2, 20},
+	}
+	for _, c := range cases {
+		_, err := ParseRaw([]byte(c.doc), c.format)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s %q: expected *ParseError, got %v", c.format, c.doc, err)
+			continue
+		}
+		if parseErr.Format != c.format || parseErr.Line != c.line || parseErr.Column != c.column {
+			t.Errorf("%s %q: expected line %d, column %d, got %v", c.format, c.doc, c.line, c.column, parseErr)
+		}
+	}
+}
+
+func TestLineColumn(t *testing.T) {
+	raw := []byte("ab\ncd\n\nef")
+	cases := map[int64][2]int{0: {1, 1}, 1: {1, 2}, 3: {2, 1}, 5: {2, 3}, 7: {4, 1}, 100: {4, 3}}
+	for offset, expected := range cases {
+		if line, column := lineColumn(raw, offset); line != expected[0] || column != expected[1] {
+			t.Errorf("offset %d: expected %v, got %d:%d", offset, expected, line, column)
+		}
+	}
+}
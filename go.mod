@@ -2,4 +2,8 @@ module github.com/sujit-baniya/flow
 
 go 1.18
 
-require golang.org/x/sync v0.0.0-20220601150217-0de741cfad7f
+require (
+	github.com/BurntSushi/toml v1.6.0
+	golang.org/x/sync v0.0.0-20220601150217-0de741cfad7f
+	gopkg.in/yaml.v3 v3.0.1
+)
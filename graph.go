@@ -0,0 +1,193 @@
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// graphEdge An edge of the graph described by a RawFlow.
+type graphEdge struct {
+	from, to string
+	label    string
+	loop     bool
+}
+
+// Vertices Returns every vertex the flow refers to, in order of appearance.
+func (r *RawFlow) Vertices() []string {
+	var vertices []string
+	seen := make(map[string]bool)
+	add := func(vs ...string) {
+		for _, v := range vs {
+			if v != "" && !seen[v] {
+				seen[v] = true
+				vertices = append(vertices, v)
+			}
+		}
+	}
+	add(r.FirstNode)
+	add(r.EntryPoints...)
+	add(r.Nodes...)
+	for _, edge := range r.Edges {
+		add(edge...)
+	}
+	for _, loop := range r.Loops {
+		add(loop...)
+	}
+	for _, forEach := range r.ForEach {
+		add(forEach.InVertex)
+		add(forEach.ChildVertex...)
+	}
+	for _, branch := range r.Branches {
+		add(branch.Key)
+		for _, condition := range sortedKeys(branch.ConditionalNodes) {
+			add(branch.ConditionalNodes[condition])
+		}
+	}
+	add(r.LastNode)
+	return vertices
+}
+
+// graphEdges Returns the edges, loop children and branch targets of the flow
+// in a deterministic order.
+func (r *RawFlow) graphEdges() []graphEdge {
+	var edges []graphEdge
+	for _, edge := range r.Edges {
+		if len(edge) == 2 {
+			edges = append(edges, graphEdge{from: edge[0], to: edge[1]})
+		}
+	}
+	for _, loop := range r.Loops {
+		if len(loop) == 0 {
+			continue
+		}
+		for _, child := range loop[1:] {
+			edges = append(edges, graphEdge{from: loop[0], to: child, label: "each", loop: true})
+		}
+	}
+	for _, forEach := range r.ForEach {
+		for _, child := range forEach.ChildVertex {
+			edges = append(edges, graphEdge{from: forEach.InVertex, to: child, label: "for each", loop: true})
+		}
+	}
+	for _, branch := range r.Branches {
+		for _, condition := range sortedKeys(branch.ConditionalNodes) {
+			edges = append(edges, graphEdge{from: branch.Key, to: branch.ConditionalNodes[condition], label: condition})
+		}
+	}
+	return edges
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// terminals Returns the entry points and last node of the flow, which are
+// highlighted in graphs.
+func (r *RawFlow) terminals() map[string]bool {
+	terminals := map[string]bool{r.FirstNode: true, r.LastNode: true}
+	if entries, err := r.Entries(); err == nil {
+		for _, v := range entries {
+			terminals[v] = true
+		}
+	}
+	return terminals
+}
+
+// DOT Returns the graph of the flow in the Graphviz DOT language. Branch
+// vertices are drawn as diamonds with their conditions as edge labels, and
+// loop children are connected by dashed edges.
+func (r *RawFlow) DOT() string {
+	branches := make(map[string]bool)
+	for _, branch := range r.Branches {
+		branches[branch.Key] = true
+	}
+	terminals := r.terminals()
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(r.Key))
+	b.WriteString("  rankdir=LR;\n")
+	for _, v := range r.Vertices() {
+		var attrs []string
+		if branches[v] {
+			attrs = append(attrs, "shape=diamond")
+		} else {
+			attrs = append(attrs, "shape=box")
+		}
+		if terminals[v] {
+			attrs = append(attrs, "peripheries=2")
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", dotQuote(v), strings.Join(attrs, ", "))
+	}
+	for _, edge := range r.graphEdges() {
+		var attrs []string
+		if edge.label != "" {
+			attrs = append(attrs, "label="+dotQuote(edge.label))
+		}
+		if edge.loop {
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(&b, "  %s -> %s", dotQuote(edge.from), dotQuote(edge.to))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
+
+// Mermaid Returns the graph of the flow as a Mermaid flowchart, drawn like DOT.
+func (r *RawFlow) Mermaid() string {
+	branches := make(map[string]bool)
+	for _, branch := range r.Branches {
+		branches[branch.Key] = true
+	}
+	terminals := r.terminals()
+	ids := make(map[string]string)
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, v := range r.Vertices() {
+		ids[v] = fmt.Sprintf("n%d", i)
+		shape := "[%s]"
+		switch {
+		case branches[v]:
+			shape = "{%s}"
+		case terminals[v]:
+			shape = "[[%s]]"
+		}
+		fmt.Fprintf(&b, "  %s"+shape+"\n", ids[v], mermaidQuote(v))
+	}
+	for _, edge := range r.graphEdges() {
+		arrow := "-->"
+		if edge.loop {
+			arrow = "-.->"
+		}
+		if edge.label != "" {
+			arrow += "|" + mermaidQuote(edge.label) + "|"
+		}
+		fmt.Fprintf(&b, "  %s %s %s\n", ids[edge.from], arrow, ids[edge.to])
+	}
+	return b.String()
+}
+
+// Raw Returns a copy of the definition the flow was built from, with the key
+// and namespace of the flow.
+func (f *Flow) Raw() *RawFlow {
+	raw := *f.raw
+	raw.Key = f.Key
+	raw.Namespace = f.Namespace
+	return &raw
+}
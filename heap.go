@@ -0,0 +1,85 @@
+package flow
+
+import "container/heap"
+
+// timeHeap Orders scheduled tasks by their next attempt, so the queue only
+// looks at tasks which are due. Ties keep enqueue order.
+type timeHeap []*Task
+
+func (h timeHeap) Len() int {
+	return len(h)
+}
+
+func (h timeHeap) Less(i, j int) bool {
+	if h[i].nextAttempt.Equal(h[j].nextAttempt) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].nextAttempt.Before(h[j].nextAttempt)
+}
+
+func (h timeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *timeHeap) Push(x interface{}) {
+	t := x.(*Task)
+	t.heapIndex = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timeHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	t.heapIndex = -1
+	return t
+}
+
+// readyHeap Orders due tasks waiting for a worker by their effective priority.
+// Ties keep enqueue order.
+type readyHeap []*Task
+
+func (h readyHeap) Len() int {
+	return len(h)
+}
+
+func (h readyHeap) Less(i, j int) bool {
+	return readyBefore(h[i], h[j])
+}
+
+// readyBefore Returns true if due task a should be started before b.
+func readyBefore(a, b *Task) bool {
+	if a.readyKey == b.readyKey {
+		return a.seq < b.seq
+	}
+	return a.readyKey > b.readyKey
+}
+
+func (h readyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *readyHeap) Push(x interface{}) {
+	t := x.(*Task)
+	t.heapIndex = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *readyHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	t.heapIndex = -1
+	return t
+}
+
+var (
+	_ heap.Interface = (*timeHeap)(nil)
+	_ heap.Interface = (*readyHeap)(nil)
+)
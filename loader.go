@@ -0,0 +1,212 @@
+package flow
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoadError Reports a flow definition file which couldn't be loaded.
+type LoadError struct {
+	Path string
+	Err  error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// LoadErrors Collects the errors of a single directory scan.
+type LoadErrors []*LoadError
+
+func (e LoadErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+type loadedFile struct {
+	modTime   time.Time
+	size      int64
+	checksum  [sha256.Size]byte
+	namespace string
+	key       string
+	versions  []int
+}
+
+// Loader Builds flows from a directory of RawFlow documents in JSON, YAML or
+// TOML, chosen by file extension, and registers them.
+// Handlers are resolved by node name, see RegisterHandler.
+//
+// Each changed file is registered as a new version of its flow, so the latest
+// version switches atomically and executions pinned to an older version keep
+// running on it. A file which fails to build leaves the previous version in
+// place. Removing a file deprecates the versions it produced.
+type Loader struct {
+	Dir       string
+	Namespace string
+	Registry  *Registry
+	Interval  time.Duration
+
+	// OnLoad Called after a flow file has been built and registered.
+	OnLoad func(path string, f *Flow)
+
+	// OnError Called when a flow file can't be read or built.
+	OnError func(err *LoadError)
+
+	mutex sync.Mutex
+	files map[string]*loadedFile
+}
+
+// defaultWatchInterval The polling interval used by Watch when Interval isn't
+// positive.
+const defaultWatchInterval = 5 * time.Second
+
+// NewLoader Creates a loader for dir which registers flows in registry, or in
+// DefaultRegistry if registry is nil. The directory is polled every 5 seconds
+// by Watch unless Interval is changed.
+func NewLoader(dir string, registry *Registry) *Loader {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &Loader{
+		Dir:      dir,
+		Registry: registry,
+		Interval: defaultWatchInterval,
+		files:    make(map[string]*loadedFile),
+	}
+}
+
+// Load Scans the directory once, building new and changed files and
+// deprecating flows whose files were removed. Returns LoadErrors if any file
+// failed.
+func (l *Loader) Load() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+	var errs LoadErrors
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		path := filepath.Join(l.Dir, entry.Name())
+		if _, ok := FormatFromExt(path); entry.IsDir() || !ok {
+			continue
+		}
+		seen[path] = true
+		if err := l.loadFile(path); err != nil {
+			loadErr := &LoadError{Path: path, Err: err}
+			if l.OnError != nil {
+				l.OnError(loadErr)
+			}
+			errs = append(errs, loadErr)
+		}
+	}
+	paths := make([]string, 0, len(l.files))
+	for path := range l.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		file := l.files[path]
+		for _, version := range file.versions {
+			_ = l.Registry.Deprecate(file.namespace, file.key, version)
+		}
+		delete(l.files, path)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (l *Loader) loadFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	file, ok := l.files[path]
+	if ok && file.modTime.Equal(info.ModTime()) && file.size == info.Size() {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	checksum := sha256.Sum256(content)
+	if ok && file.checksum == checksum {
+		file.modTime, file.size = info.ModTime(), info.Size()
+		return nil
+	}
+	if !ok {
+		file = &loadedFile{}
+	}
+	// Mark the file as seen before building, so an invalid revision isn't
+	// rebuilt on every scan until it changes again.
+	file.modTime, file.size, file.checksum = info.ModTime(), info.Size(), checksum
+	l.files[path] = file
+
+	format, _ := FormatFromExt(path)
+	if err := ValidateWithFormat(format, content); err != nil {
+		return err
+	}
+	f := NewWithFormat(format, content)
+	if f.Error != nil {
+		return f.Error
+	}
+	if f.Key == "" {
+		f.Key = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if f.Namespace == "" {
+		f.Namespace = l.Namespace
+	}
+	if file.key != "" && (file.key != f.Key || file.namespace != f.Namespace) {
+		return fmt.Errorf("flow key changed from '%s' to '%s'; rename the file instead", file.key, f.Key)
+	}
+	if f.WithRegistry(l.Registry).Build().Error != nil {
+		return f.Error
+	}
+	file.namespace, file.key = f.Namespace, f.Key
+	file.versions = append(file.versions, f.Version)
+	if l.OnLoad != nil {
+		l.OnLoad(path, f)
+	}
+	return nil
+}
+
+// Watch Loads the directory and then polls it for changes every Interval
+// until the context is cancelled, or every 5 seconds if Interval isn't
+// positive. Errors are reported through OnError.
+func (l *Loader) Watch(ctx context.Context) {
+	interval := l.Interval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_ = l.Load()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
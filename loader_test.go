@@ -0,0 +1,110 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoader(t *testing.T) {
+	RegisterHandler("loader-receive", echo)
+	RegisterHandler("loader-check", echo)
+	RegisterHandler("loader-ship", echo)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "orders.yaml")
+	modTime := time.Now().Add(-time.Hour)
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registry := NewRegistry()
+	loader := NewLoader(dir, registry)
+	var loaded []string
+	loader.OnLoad = func(path string, f *Flow) {
+		loaded = append(loaded, filepath.Base(path))
+	}
+	var failed []*LoadError
+	loader.OnError = func(err *LoadError) {
+		failed = append(failed, err)
+	}
+	versions := func() []FlowVersion {
+		return registry.Versions("", "orders")
+	}
+
+	write("edges:\n  - [loader-receive, loader-check]\n")
+	if err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if f := registry.Get("", "orders"); f == nil || f.Version != 1 || len(loaded) != 1 {
+		t.Fatalf("expected version 1 to be loaded from the file name, got %v", versions())
+	}
+
+	// An unchanged file, or one only touched, isn't rebuilt
+	if err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+	modTime = modTime.Add(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions()) != 1 || len(loaded) != 1 {
+		t.Fatalf("expected no new version, got %v", versions())
+	}
+
+	write("edges:\n  - [loader-receive, loader-check]\n  - [loader-check, loader-ship]\n")
+	if err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if f := registry.Get("", "orders"); f == nil || f.Version != 2 {
+		t.Fatalf("expected version 2 after an edit, got %v", versions())
+	}
+
+	// An invalid edit keeps the last good version, and isn't retried until
+	// the file changes again
+	write("edges:\n  - [loader-receive]\n")
+	var errs LoadErrors
+	if err := loader.Load(); !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != path {
+		t.Fatalf("expected a LoadError for %s, got %v", path, err)
+	}
+	if err := loader.Load(); err != nil || len(failed) != 1 {
+		t.Fatalf("expected the invalid revision to be reported once, got %v (%d)", err, len(failed))
+	}
+	if f := registry.Get("", "orders"); f == nil || f.Version != 2 {
+		t.Fatalf("expected version 2 to stay active, got %v", versions())
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if f := registry.Get("", "orders"); f != nil {
+		t.Fatalf("expected no active version after the file was removed, got %d", f.Version)
+	}
+	for _, v := range versions() {
+		if v.State != VersionDeprecated {
+			t.Errorf("expected version %d to be deprecated, got %s", v.Version, v.State)
+		}
+	}
+}
+
+func TestLoader_WatchZeroInterval(t *testing.T) {
+	loader := NewLoader(t.TempDir(), NewRegistry())
+	loader.Interval = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	loader.Watch(ctx)
+}
@@ -42,8 +42,8 @@ func AppendString(ctx context.Context, d Data) (Data, error) {
 	return d, nil
 }
 
-func BenchmarkFlow_Loop(b *testing.B) {
-	flow1 := New()
+func loopFlow() *Flow {
+	flow1 := New().WithRegistry(NewRegistry())
 	flow1.AddNode("get-sentence", GetSentence)
 	flow1.AddNode("for-each-word", ForEachWord)
 	flow1.AddNode("upper-case", WordUpperCase)
@@ -52,9 +52,52 @@ func BenchmarkFlow_Loop(b *testing.B) {
 	flow1.Loop("for-each-word", "append-ip", "upper-case")
 	flow1.Edge("get-sentence", "for-each-word")
 	flow1.Edge("upper-case", "append-string")
+	return flow1
+}
+
+func BenchmarkFlow_Loop(b *testing.B) {
+	flow1 := loopFlow()
 	for i := 0; i < b.N; i++ {
 		flow1.Process(context.Background(), Data{
 			Payload: Payload("this is a sentence"),
 		})
 	}
 }
+
+// BenchmarkVertex_Loop Walks the linked vertices, as Process did before flows
+// were compiled.
+func BenchmarkVertex_Loop(b *testing.B) {
+	flow1 := loopFlow().Build()
+	first := flow1.entries[0]
+	for i := 0; i < b.N; i++ {
+		first.Process(context.Background(), Data{
+			Payload: Payload("this is a sentence"),
+		})
+	}
+}
+
+func BenchmarkPlan_Loop(b *testing.B) {
+	plan, err := loopFlow().Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		plan.Process(context.Background(), Data{
+			Payload: Payload("this is a sentence"),
+		})
+	}
+}
+
+func BenchmarkPlan_LoopParallel(b *testing.B) {
+	plan, err := loopFlow().Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			plan.Process(context.Background(), Data{
+				Payload: Payload("this is a sentence"),
+			})
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package flow
 
-import "context"
+import (
+	"context"
+	"sort"
+	"sync"
+)
 
 type Node interface {
 	Process(ctx context.Context, data Data) (Data, error)
@@ -10,3 +14,35 @@ type Node interface {
 }
 
 type Handler func(ctx context.Context, data Data) (Data, error)
+
+var handlers = struct {
+	sync.RWMutex
+	m map[string]Handler
+}{m: make(map[string]Handler)}
+
+// RegisterHandler Registers a handler by name so flows defined only by their
+// RawFlow, such as those loaded from files, can resolve their nodes.
+func RegisterHandler(name string, handler Handler) {
+	handlers.Lock()
+	handlers.m[name] = handler
+	handlers.Unlock()
+}
+
+// LookupHandler Returns the handler registered under name, or nil.
+func LookupHandler(name string) Handler {
+	handlers.RLock()
+	defer handlers.RUnlock()
+	return handlers.m[name]
+}
+
+// HandlerNames Returns the sorted names of all registered handlers.
+func HandlerNames() []string {
+	handlers.RLock()
+	defer handlers.RUnlock()
+	names := make([]string, 0, len(handlers.m))
+	for name := range handlers.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
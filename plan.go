@@ -0,0 +1,325 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrCycle Returned when compiling a flow whose vertices form a cycle.
+var ErrCycle = errors.New("cycle detected")
+
+// planStep A vertex of a Plan with its successors resolved to step indexes.
+// Nodes which aren't vertices, such as nested flows, are opaque steps that
+// process their own successors.
+type planStep struct {
+	key        string
+	kind       string
+	node       Node
+	handler    Handler
+	conditions []Condition
+	// unconditional Marks a branch without conditional nodes, which fails
+	// like Vertex.Process does.
+	unconditional bool
+	branches      map[string]int
+	loop          []Node
+	successors    []int
+}
+
+// Plan Immutable execution plan of a built Flow. Its steps are sorted
+// topologically and their successors are precomputed, so processing walks
+// slices instead of the linked vertices. A Plan is safe for concurrent use and
+// can be reused across runs.
+type Plan struct {
+	Key       string
+	Namespace string
+
+	steps   []planStep
+	index   map[string]int
+	entries []Node
+	last    Node
+}
+
+// planNode Node processing one step of a Plan, used for entry points and loop
+// children.
+type planNode struct {
+	plan *Plan
+	step int
+}
+
+func (n planNode) Process(ctx context.Context, data Data) (Data, error) {
+	return n.plan.process(ctx, n.step, data)
+}
+
+// AddEdge Does nothing, since a Plan can't be modified.
+func (n planNode) AddEdge(Node) {}
+
+func (n planNode) GetType() string {
+	return n.plan.steps[n.step].kind
+}
+
+func (n planNode) GetKey() string {
+	return n.plan.steps[n.step].key
+}
+
+// Compile Returns the execution plan of the flow, building it first if needed.
+// The plan is compiled when the flow is built, so Build reports cycles too.
+// Concurrent calls build an unbuilt flow once.
+func (f *Flow) Compile() (*Plan, error) {
+	f.compiling.Lock()
+	defer f.compiling.Unlock()
+	if f.Error != nil {
+		return nil, f.Error
+	}
+	if f.plan == nil {
+		if t := f.Build(); t.Error != nil {
+			return nil, t.Error
+		}
+	}
+	return f.plan, nil
+}
+
+// compile Sorts the built vertices of the flow topologically and resolves their
+// successors. Returns ErrCycle if the vertices can't be sorted.
+func (f *Flow) compile() (*Plan, error) {
+	order, err := topologicalOrder(f.nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{
+		Key:       f.Key,
+		Namespace: f.Namespace,
+		steps:     make([]planStep, len(order)),
+		index:     make(map[string]int, len(order)),
+	}
+	for i, key := range order {
+		p.index[key] = i
+	}
+	for i, key := range order {
+		v, ok := f.nodes[key].(*Vertex)
+		if !ok {
+			p.steps[i] = planStep{key: key, kind: f.nodes[key].GetType(), node: f.nodes[key]}
+			continue
+		}
+		step := planStep{
+			key:           key,
+			kind:          v.Type,
+			handler:       v.handler,
+			conditions:    v.conditions,
+			unconditional: v.Type == "Branch" && len(v.ConditionalNodes) == 0,
+		}
+		if len(v.branches) > 0 {
+			step.branches = make(map[string]int, len(v.branches))
+			for condition, target := range v.branches {
+				step.branches[condition] = p.index[target.GetKey()]
+			}
+		}
+		for _, child := range sortedNodes(v.loops) {
+			step.loop = append(step.loop, planNode{plan: p, step: p.index[child.GetKey()]})
+		}
+		for _, next := range sortedNodes(v.edges) {
+			step.successors = append(step.successors, p.index[next.GetKey()])
+		}
+		p.steps[i] = step
+	}
+	for _, entry := range f.entries {
+		p.entries = append(p.entries, planNode{plan: p, step: p.index[entry.GetKey()]})
+	}
+	if f.lastNode != nil {
+		p.last = planNode{plan: p, step: p.index[f.lastNode.GetKey()]}
+	}
+	return p, nil
+}
+
+// topologicalOrder Sorts nodes so each vertex comes before its edges, branch
+// targets and loop children, breaking ties by key.
+func topologicalOrder(vertices map[string]Node) ([]string, error) {
+	inDegree := make(map[string]int, len(vertices))
+	successors := make(map[string][]string, len(vertices))
+	for key, n := range vertices {
+		inDegree[key] += 0
+		v, ok := n.(*Vertex)
+		if !ok {
+			continue
+		}
+		var next []string
+		for _, n := range sortedNodes(v.edges) {
+			next = append(next, n.GetKey())
+		}
+		for _, n := range sortedNodes(v.loops) {
+			next = append(next, n.GetKey())
+		}
+		for _, target := range v.branches {
+			next = append(next, target.GetKey())
+		}
+		for _, n := range next {
+			if _, ok := vertices[n]; !ok {
+				return nil, fmt.Errorf("vertex '%s' refers to '%s', which isn't built", key, n)
+			}
+			inDegree[n]++
+		}
+		successors[key] = next
+	}
+	var ready []string
+	for key, degree := range inDegree {
+		if degree == 0 {
+			ready = append(ready, key)
+		}
+	}
+	order := make([]string, 0, len(vertices))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		key := ready[0]
+		ready = ready[1:]
+		order = append(order, key)
+		for _, n := range successors[key] {
+			if inDegree[n]--; inDegree[n] == 0 {
+				ready = append(ready, n)
+			}
+		}
+	}
+	if len(order) < len(vertices) {
+		var cyclic []string
+		for key, degree := range inDegree {
+			if degree > 0 {
+				cyclic = append(cyclic, key)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, fmt.Errorf("%w among vertices %s", ErrCycle, strings.Join(cyclic, ", "))
+	}
+	return order, nil
+}
+
+// Order Returns the vertices of the plan in topological order.
+func (p *Plan) Order() []string {
+	order := make([]string, len(p.steps))
+	for i, step := range p.steps {
+		order[i] = step.key
+	}
+	return order
+}
+
+// Successors Returns the vertices processed after a vertex: its branch targets
+// by condition, then its edges in the order they are processed.
+func (p *Plan) Successors(vertex string) []string {
+	i, ok := p.index[vertex]
+	if !ok {
+		return nil
+	}
+	step := p.steps[i]
+	var successors []string
+	for _, condition := range sortedIndexKeys(step.branches) {
+		successors = append(successors, p.steps[step.branches[condition]].key)
+	}
+	for _, next := range step.successors {
+		successors = append(successors, p.steps[next].key)
+	}
+	return successors
+}
+
+// Unreachable Returns the vertices which are never processed, since no entry
+// point or last node leads to them.
+func (p *Plan) Unreachable() []string {
+	reached := make([]bool, len(p.steps))
+	var visit func(i int)
+	visit = func(i int) {
+		if reached[i] {
+			return
+		}
+		reached[i] = true
+		step := p.steps[i]
+		for _, target := range step.branches {
+			visit(target)
+		}
+		for _, child := range step.loop {
+			visit(child.(planNode).step)
+		}
+		for _, next := range step.successors {
+			visit(next)
+		}
+	}
+	for _, entry := range p.entries {
+		visit(entry.(planNode).step)
+	}
+	if p.last != nil {
+		visit(p.last.(planNode).step)
+	}
+	var unreachable []string
+	for i, step := range p.steps {
+		if !reached[i] {
+			unreachable = append(unreachable, step.key)
+		}
+	}
+	return unreachable
+}
+
+func sortedIndexKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Process Runs the plan with data, like Flow.Process.
+func (p *Plan) Process(ctx context.Context, data Data) (Data, error) {
+	if len(p.entries) == 0 {
+		return data, errors.New("no edges defined")
+	}
+	d, err := processEntries(ctx, p.entries, data)
+	if err != nil {
+		return d, err
+	}
+	if p.last != nil {
+		return p.last.Process(ctx, d)
+	}
+	return d, nil
+}
+
+// process Processes a step and its successors like Vertex.Process.
+func (p *Plan) process(ctx context.Context, i int, data Data) (Data, error) {
+	step := &p.steps[i]
+	if step.node != nil {
+		return step.node.Process(ctx, data)
+	}
+	if step.unconditional {
+		return data, errors.New("required at least one condition for branch")
+	}
+	response, err := step.handler(ctx, data)
+	if err != nil {
+		return data, err
+	}
+	if step.kind == "Loop" {
+		result, err := processLoop(ctx, step.loop, data, response)
+		if err != nil {
+			return data, err
+		}
+		tmp, err := json.Marshal(result)
+		if err != nil {
+			return data, err
+		}
+		response.Payload = tmp
+	}
+	status, err := branchStatus(step.key, step.conditions, response)
+	if err != nil {
+		return data, err
+	}
+	if target, ok := step.branches[status]; ok {
+		response, err = p.process(ctx, target, response)
+		response.FailedReason = err
+	}
+	for _, next := range step.successors {
+		response, err = p.process(ctx, next, response)
+		response.FailedReason = err
+		if err != nil {
+			return data, err
+		}
+	}
+	return response, err
+}
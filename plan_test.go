@@ -0,0 +1,126 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestFlow_Compile(t *testing.T) {
+	f := New().WithRegistry(NewRegistry())
+	for _, v := range []string{"receive", "check", "ship", "review", "notify", "orphan"} {
+		f.AddNode(v, echo)
+	}
+	f.Edge("receive", "check").
+		Edge("ship", "notify").
+		Edge("review", "notify").
+		ConditionalNode("check", map[string]string{"ok": "ship", "manual": "review"}).
+		EntryPoints("receive")
+	plan, err := f.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"orphan", "receive", "check", "review", "ship", "notify"}
+	if order := plan.Order(); !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+	if successors := plan.Successors("check"); !reflect.DeepEqual(successors, []string{"review", "ship"}) {
+		t.Errorf("unexpected successors %v", successors)
+	}
+	if unreachable := plan.Unreachable(); !reflect.DeepEqual(unreachable, []string{"orphan"}) {
+		t.Errorf("expected orphan to be unreachable, got %v", unreachable)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := f.Process(context.Background(), Data{Payload: Payload("x"), Status: "ok"})
+			if err != nil || string(result.Payload) != "x" {
+				t.Errorf("unexpected result %s %v", result.Payload, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFlow_ProcessUnbuilt(t *testing.T) {
+	registry := NewRegistry()
+	f := New().WithRegistry(registry).
+		AddNode("receive", echo).
+		AddNode("ship", echo).
+		Edge("receive", "ship")
+	f.Key = "unbuilt"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := f.Process(context.Background(), Data{Payload: Payload("x")})
+			if err != nil || string(result.Payload) != "x" {
+				t.Errorf("unexpected result %s %v", result.Payload, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if versions := registry.Versions("", "unbuilt"); len(versions) != 1 {
+		t.Errorf("expected the flow to be built once, got %d versions", len(versions))
+	}
+}
+
+func TestFlow_CompileCycle(t *testing.T) {
+	f := New().WithRegistry(NewRegistry()).
+		AddNode("a", echo).
+		AddNode("b", echo).
+		AddNode("c", echo).
+		Edge("a", "b").
+		Edge("b", "c").
+		Edge("c", "b").
+		Build()
+	if !errors.Is(f.Error, ErrCycle) {
+		t.Fatalf("expected ErrCycle, got %v", f.Error)
+	}
+	if _, err := f.Process(context.Background(), Data{}); !errors.Is(err, ErrCycle) {
+		t.Errorf("expected Process to fail with ErrCycle, got %v", err)
+	}
+}
+
+func TestFlow_CompileNestedFlow(t *testing.T) {
+	appendTo := func(s string) Handler {
+		return func(ctx context.Context, d Data) (Data, error) {
+			d.Payload = Payload(string(d.Payload) + s)
+			return d, nil
+		}
+	}
+	sub := New().WithRegistry(NewRegistry()).
+		AddNode("x", appendTo("x")).
+		AddNode("y", appendTo("y")).
+		Edge("x", "y").
+		Build()
+	if sub.Error != nil {
+		t.Fatal(sub.Error)
+	}
+	sub.Key = "sub"
+
+	f := New().WithRegistry(NewRegistry()).AddNode("a", appendTo("a"))
+	f.AddEdge(sub)
+	f.Edge("a", "sub")
+	plan, err := f.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order := plan.Order(); !reflect.DeepEqual(order, []string{"a", "sub"}) {
+		t.Errorf("unexpected order %v", order)
+	}
+	result, err := f.Process(context.Background(), Data{Payload: Payload(">")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Payload) != ">axy" {
+		t.Errorf("expected >axy, got %s", result.Payload)
+	}
+}
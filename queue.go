@@ -1,22 +1,83 @@
 package flow
 
 import (
+	"container/heap"
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-var ErrQueueShuttingDown = errors.New("queue is shutting down; new tasks are not being accepted")
+var (
+	ErrQueueShuttingDown = errors.New("queue is shutting down; new tasks are not being accepted")
+
+	// ErrTaskNotFound Returned when no pending or running task has the given ID.
+	ErrTaskNotFound = errors.New("task not found")
+
+	// ErrDuplicateTaskID Returned when enqueueing a task with the ID of a task
+	// which is still pending or running.
+	ErrDuplicateTaskID = errors.New("a task with this ID is already queued")
+)
+
+// TaskInfo Snapshot of the state of a queued task.
+type TaskInfo struct {
+	ID          string                 `json:"id"`
+	Type        string                 `json:"type,omitempty"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Priority    int                    `json:"priority"`
+	Attempts    int                    `json:"attempts"`
+	MaxAttempts int                    `json:"max_attempts"`
+	NextAttempt time.Time              `json:"next_attempt"`
+	Running     bool                   `json:"running"`
+	LastError   string                 `json:"last_error,omitempty"`
+}
 
 type Queue struct {
 	mutex sync.Mutex
 	name  string
 	next  time.Time
 	now   func() time.Time
-	tasks []*Task
 	wg    sync.WaitGroup
+	store QueueStore
+	types map[string]TaskTypeFunc
+
+	afters      map[string]func(ctx context.Context, task *Task)
+	deadLetters DeadLetterStore
+
+	limiter     *TokenBucket
+	keyLimiters map[string]*TokenBucket
+	rateKey     string
+	keyRate     float64
+	keyBurst    int
+	inFlightKey string
+	keyInFlight int
+	keyActive   map[string]int
+
+	uniques       map[string]*uniqueEntry
+	completedKeys []string
+	uniqueWindow  time.Duration
+	duplicates    DuplicatePolicy
+
+	// tasks holds every task which isn't done, by ID. A task is either in
+	// the scheduled heap, in the ready heap of its fair-share group, or
+	// running on a worker.
+	tasks     map[string]*Task
+	scheduled timeHeap
+	ready     map[string]*readyHeap
+	active    map[string]int
+	seq       uint64
+
+	workers  int
+	running  int
+	inflight sync.WaitGroup
+	aging    time.Duration
+	fairKey  string
+	served   map[string]uint64
+	serveSeq uint64
 
 	accept   int32
 	shutdown chan struct{}
@@ -32,9 +93,21 @@ func NewQueue(name string) *Queue {
 		now: func() time.Time {
 			return time.Now().UTC()
 		},
-		accept:   1,
-		shutdown: make(chan struct{}),
-		started:  make(chan struct{}, 1),
+		types:       make(map[string]TaskTypeFunc),
+		afters:      make(map[string]func(ctx context.Context, task *Task)),
+		deadLetters: NewMemoryDeadLetters(),
+		uniques:     make(map[string]*uniqueEntry),
+		keyLimiters: make(map[string]*TokenBucket),
+		keyActive:   make(map[string]int),
+		tasks:       make(map[string]*Task),
+		ready:       make(map[string]*readyHeap),
+		active:      make(map[string]int),
+		workers:     1,
+		aging:       time.Minute,
+		served:      make(map[string]uint64),
+		accept:      1,
+		shutdown:    make(chan struct{}),
+		started:     make(chan struct{}, 1),
 	}
 }
 
@@ -43,22 +116,163 @@ func (q *Queue) Now(now func() time.Time) {
 	q.now = now
 }
 
+// Workers Sets the number of tasks the queue attempts concurrently. Defaults to
+// one, which attempts due tasks one after another.
+func (q *Queue) Workers(n int) {
+	if n < 1 {
+		panic(errors.New("invalid input to Queue.Workers"))
+	}
+	q.mutex.Lock()
+	q.workers = n
+	q.mutex.Unlock()
+}
+
+// Aging Sets how long a due task has to wait for a worker to gain one priority
+// level, so low-priority tasks aren't starved by a steady stream of higher
+// priority ones. Defaults to one minute; zero disables aging.
+func (q *Queue) Aging(d time.Duration) {
+	if d < 0 {
+		panic(errors.New("invalid input to Queue.Aging"))
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.aging = d
+	for _, ready := range q.ready {
+		for _, task := range *ready {
+			q.setReadyKey(task)
+		}
+		heap.Init(ready)
+	}
+}
+
+// FairShare Enables fair-share scheduling across the values of a metadata key,
+// such as a tenant or user ID. When workers are scarce, due tasks are taken
+// from the key value with the fewest running attempts first, and then from the
+// one served least recently, so one key can't monopolize the queue. Priorities
+// only order tasks within the same key value. An empty key disables fair-share
+// scheduling.
+func (q *Queue) FairShare(metadataKey string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.fairKey = metadataKey
+	var ready []*Task
+	for _, h := range q.ready {
+		ready = append(ready, *h...)
+	}
+	q.ready = make(map[string]*readyHeap)
+	for _, task := range ready {
+		q.makeReady(task)
+	}
+}
+
+// signal Wakes the run loop without blocking. Must be called with the mutex
+// held.
+func (q *Queue) signal() {
+	if q.wake == nil {
+		return
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Storage Sets the store used to persist typed tasks. Pending tasks found in
+// the store are reloaded when the queue is started.
+func (q *Queue) Storage(store QueueStore) {
+	q.store = store
+}
+
+// RegisterTaskType Registers a task type for this queue only, taking precedence
+// over types registered with the package-level RegisterTaskType.
+func (q *Queue) RegisterTaskType(name string, fn TaskTypeFunc) {
+	q.mutex.Lock()
+	q.types[name] = fn
+	q.mutex.Unlock()
+}
+
+// AfterTaskType Sets an 'After' function for every task of a type enqueued on
+// this queue which has none of its own. Unlike the task's own function, it is
+// kept for tasks reloaded from storage or re-driven from the dead letters.
+func (q *Queue) AfterTaskType(name string, fn func(ctx context.Context, task *Task)) {
+	q.mutex.Lock()
+	q.afters[name] = fn
+	q.mutex.Unlock()
+}
+
+// resolve Binds a typed task to its function and 'After' function for its type,
+// and assigns it an ID. Must be called with the mutex held.
+func (q *Queue) resolve(t *Task) error {
+	if t.id == "" {
+		t.id = newID()
+	}
+	if after, ok := q.afters[t.typeName]; ok && t.after == nil && t.typeName != "" {
+		t.after = after
+	}
+	if t.typeName == "" || t.fn != nil {
+		return nil
+	}
+	fn, ok := q.types[t.typeName]
+	if !ok {
+		fn = LookupTaskType(t.typeName)
+	}
+	if fn == nil {
+		return fmt.Errorf("%w: '%s'", ErrUnknownTaskType, t.typeName)
+	}
+	t.bind(fn)
+	return nil
+}
+
+// persist Saves a typed task to the store, or deletes it once it is done.
+func (q *Queue) persist(t *Task) error {
+	if q.store == nil || t.typeName == "" {
+		return nil
+	}
+	if t.Done() {
+		return q.store.Delete(q.name, t.id)
+	}
+	record := t.record()
+	if t.backoff != nil && record.Backoff == nil {
+		return fmt.Errorf("%w: %T", ErrBackoffNotPersistable, t.backoff)
+	}
+	return q.store.Save(q.name, record)
+}
+
 // Enqueue Enqueues a task.
 //
-// An error will only be returned if the queue has been shut down.
+// An error will be returned if the queue has been shut down, if a typed task
+// refers to an unknown type, if the task is a rejected duplicate or if the task
+// couldn't be persisted.
 func (q *Queue) Enqueue(t *Task) error {
 	if atomic.LoadInt32(&q.accept) == 0 {
 		return ErrQueueShuttingDown
 	}
 
 	q.mutex.Lock()
-	q.tasks = append(q.tasks, t)
-	if q.wake != nil {
-		// Runs asynchronously to avoid deadlocking if a task submits another task
-		go func() {
-			q.wake <- struct{}{}
-		}()
+	if err := q.resolve(t); err != nil {
+		q.mutex.Unlock()
+		return err
+	}
+	if _, ok := q.tasks[t.id]; ok {
+		q.mutex.Unlock()
+		return fmt.Errorf("%w: '%s'", ErrDuplicateTaskID, t.id)
+	}
+	if duplicate, err := q.deduplicate(t); duplicate {
+		q.mutex.Unlock()
+		return err
+	}
+	if err := q.persist(t); err != nil {
+		q.releaseUnique(t)
+		q.mutex.Unlock()
+		return err
+	}
+	if t.enqueuedAt.IsZero() {
+		t.enqueuedAt = q.now()
 	}
+	q.schedule(t)
+	// The wake channel is buffered and signalled without blocking, so a task
+	// may submit another task without deadlocking
+	q.signal()
 	q.mutex.Unlock()
 	return nil
 }
@@ -76,41 +290,360 @@ func (q *Queue) Submit(fn TaskFunc) (*Task, error) {
 	return t, err
 }
 
-// Dispatch Attempts any tasks which are due and updates the task schedule. Returns true
-// if there is more work to do.
+// Dispatch Starts attempts of any tasks which are due on free workers and
+// updates the task schedule. Returns true if there is more work to do,
+// including attempts which are still running. Dispatch doesn't wait for the
+// attempts it starts.
+//
+// Its cost is proportional to the number of due tasks, not to the size of the
+// queue.
 func (q *Queue) Dispatch(ctx context.Context) bool {
-	next := time.Unix(1<<63-62135596801, 999999999) // "max" time
 	now := q.now()
 
-	// In order to avoid deadlocking if a task queues another task, we make a
-	// copy of the task list and release the mutex while executing them.
 	q.mutex.Lock()
-	tasks := make([]*Task, len(q.tasks))
-	copy(tasks, q.tasks)
-	q.mutex.Unlock()
+	defer q.mutex.Unlock()
 
-	for _, task := range tasks {
-		due := task.NextAttempt().Before(now)
-		if due {
-			_, _ = task.Attempt(ctx)
+	for len(q.scheduled) > 0 && q.scheduled[0].NextAttempt().Before(now) {
+		// Due tasks waiting for a worker are picked up when an attempt
+		// finishes and wakes the run loop
+		q.makeReady(heap.Pop(&q.scheduled).(*Task))
+	}
+	q.next = time.Unix(1<<63-62135596801, 999999999) // "max" time
+	var capped []*Task
+	for q.running < q.workers {
+		if q.limiter != nil {
+			if wait := q.limiter.Wait(now); wait > 0 {
+				q.next = now.Add(wait)
+				break
+			}
+		}
+		task := q.pick()
+		if task == nil {
+			break
+		}
+		start, wait := q.admit(task, now)
+		switch {
+		case start:
+			q.start(ctx, task)
+		case wait > 0:
+			// Rate limited tasks are rescheduled without using an attempt
+			task.nextAttempt = now.Add(wait)
+			heap.Push(&q.scheduled, task)
+		default:
+			capped = append(capped, task)
+		}
+	}
+	// Capped tasks become eligible when an attempt finishes and wakes the
+	// run loop
+	for _, task := range capped {
+		q.makeReady(task)
+	}
+	q.pruneLimiters(now)
+	if len(q.scheduled) > 0 && q.scheduled[0].NextAttempt().Before(q.next) {
+		q.next = q.scheduled[0].NextAttempt()
+	}
+	return len(q.tasks) != 0
+}
+
+// schedule Adds a task to the scheduled heap. Must be called with the mutex
+// held.
+func (q *Queue) schedule(task *Task) {
+	if task.seq == 0 {
+		q.seq++
+		task.seq = q.seq
+	}
+	q.tasks[task.id] = task
+	heap.Push(&q.scheduled, task)
+}
+
+// shareOf Returns the fair-share group of a task.
+func (q *Queue) shareOf(task *Task) string {
+	if q.fairKey == "" {
+		return ""
+	}
+	return fmt.Sprint(task.Metadata[q.fairKey])
+}
+
+// setReadyKey Computes the key ordering a due task in its ready heap. The
+// effective priority of a task is its priority plus one level for every aging
+// period it has been waiting; since all tasks age at the same rate, ordering by
+// priority minus the aging periods elapsed at readyAt is equivalent and
+// doesn't change over time.
+func (q *Queue) setReadyKey(task *Task) {
+	task.readyKey = float64(task.priority)
+	if q.aging > 0 {
+		readyAt := task.NextAttempt()
+		if task.enqueuedAt.After(readyAt) {
+			readyAt = task.enqueuedAt
+		}
+		task.readyKey -= float64(readyAt.UnixNano()) / float64(q.aging)
+	}
+}
+
+// makeReady Adds a due task to the ready heap of its fair-share group. Must be
+// called with the mutex held.
+func (q *Queue) makeReady(task *Task) {
+	q.setReadyKey(task)
+	share := q.shareOf(task)
+	ready, ok := q.ready[share]
+	if !ok {
+		ready = &readyHeap{}
+		q.ready[share] = ready
+	}
+	heap.Push(ready, task)
+}
+
+// pick Removes and returns the ready task to start next, or nil: the task with
+// the highest effective priority of the fair-share group with the fewest
+// running attempts, served least recently. Must be called with the mutex held.
+func (q *Queue) pick() *Task {
+	var best string
+	var bestReady *readyHeap
+	for share, ready := range q.ready {
+		if bestReady == nil {
+			best, bestReady = share, ready
+			continue
+		}
+		switch {
+		case q.active[share] != q.active[best]:
+			if q.active[share] < q.active[best] {
+				best, bestReady = share, ready
+			}
+		case q.served[share] != q.served[best]:
+			if q.served[share] < q.served[best] {
+				best, bestReady = share, ready
+			}
+		case readyBefore((*ready)[0], (*bestReady)[0]):
+			best, bestReady = share, ready
 		}
-		if !task.Done() && task.NextAttempt().Before(next) {
-			next = task.NextAttempt()
+	}
+	if bestReady == nil {
+		return nil
+	}
+	task := heap.Pop(bestReady).(*Task)
+	if bestReady.Len() == 0 {
+		delete(q.ready, best)
+	}
+	if q.fairKey != "" {
+		q.serveSeq++
+		q.served[best] = q.serveSeq
+	}
+	return task
+}
+
+// start Attempts a task on a new worker. Must be called with the mutex held.
+//
+// In order to avoid deadlocking if a task queues another task, the mutex is
+// released while the task is executed.
+func (q *Queue) start(ctx context.Context, task *Task) {
+	share := q.shareOf(task)
+	task.running = true
+	q.running++
+	q.active[share]++
+	limitKey := ""
+	if q.inFlightKey != "" {
+		limitKey = metadataValue(task, q.inFlightKey)
+		q.keyActive[limitKey]++
+	}
+	q.inflight.Add(1)
+	// The worker owns the task's fields while it runs, so inspection reads a
+	// snapshot taken before the attempt
+	snapshot := task.info()
+	snapshot.Attempts++
+	task.snapshot = &snapshot
+	ctx, task.cancel = context.WithCancel(ctx)
+	go func() {
+		defer q.inflight.Done()
+		_, err := task.Attempt(ctx)
+
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		task.snapshot = nil
+		// The task is still owned by this worker, so it can be finished
+		// without the mutex, as its 'After' function may enqueue tasks.
+		// Cancel may be called meanwhile, so it is checked again after.
+		for task.cancelled && err != nil && !task.Done() {
+			q.mutex.Unlock()
+			task.finish(ctx, ErrTaskCancelled)
+			q.mutex.Lock()
 		}
+		if err := q.persist(task); err != nil {
+			log.Printf("Failed to persist task %s: %v", task.id, err)
+		}
+		task.cancel()
+		task.cancel = nil
+		task.running = false
+		q.running--
+		if limitKey != "" {
+			if q.keyActive[limitKey]--; q.keyActive[limitKey] <= 0 {
+				delete(q.keyActive, limitKey)
+			}
+		}
+		if q.active[share]--; q.active[share] == 0 {
+			delete(q.active, share)
+			if _, ok := q.ready[share]; !ok {
+				delete(q.served, share)
+			}
+		}
+		if task.Done() {
+			delete(q.tasks, task.id)
+			q.releaseUnique(task)
+			if errors.Is(task.err, ErrMaxRetriesExceeded) {
+				q.bury(task)
+			}
+		} else {
+			q.schedule(task)
+		}
+		q.signal()
+	}()
+}
+
+func (t *Task) info() TaskInfo {
+	if t.snapshot != nil {
+		return *t.snapshot
+	}
+	info := TaskInfo{
+		ID:          t.id,
+		Type:        t.typeName,
+		Metadata:    make(map[string]interface{}, len(t.Metadata)),
+		Priority:    t.priority,
+		Attempts:    t.attempts,
+		MaxAttempts: t.maxAttempts,
+		NextAttempt: t.nextAttempt,
+		Running:     t.running,
+	}
+	for k, v := range t.Metadata {
+		info.Metadata[k] = v
+	}
+	if err := t.LastError(); err != nil {
+		info.LastError = err.Error()
 	}
+	return info
+}
 
+// Tasks Returns a snapshot of every pending and running task, ordered by their
+// next attempt.
+func (q *Queue) Tasks() []TaskInfo {
 	q.mutex.Lock()
-	newTasks := make([]*Task, 0, len(q.tasks))
+	defer q.mutex.Unlock()
+	tasks := make([]*Task, 0, len(q.tasks))
 	for _, task := range q.tasks {
-		if !task.Done() {
-			newTasks = append(newTasks, task)
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].nextAttempt.Equal(tasks[j].nextAttempt) {
+			return tasks[i].seq < tasks[j].seq
+		}
+		return tasks[i].nextAttempt.Before(tasks[j].nextAttempt)
+	})
+	infos := make([]TaskInfo, 0, len(tasks))
+	for _, task := range tasks {
+		infos = append(infos, task.info())
+	}
+	return infos
+}
+
+// Get Returns a snapshot of a pending or running task.
+func (q *Queue) Get(id string) (TaskInfo, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	task, ok := q.tasks[id]
+	if !ok {
+		return TaskInfo{}, fmt.Errorf("%w: '%s'", ErrTaskNotFound, id)
+	}
+	return task.info(), nil
+}
+
+// Cancel Cancels a pending or running task. A pending task is removed from the
+// queue; a running attempt has its context cancelled, and the task is finished
+// once the attempt returns. Either way the task is done with ErrTaskCancelled
+// and its 'After' function runs, unless the running attempt still succeeded.
+func (q *Queue) Cancel(id string) error {
+	q.mutex.Lock()
+	task, ok := q.tasks[id]
+	if !ok {
+		q.mutex.Unlock()
+		return fmt.Errorf("%w: '%s'", ErrTaskNotFound, id)
+	}
+	task.cancelled = true
+	if task.running {
+		if task.cancel != nil {
+			task.cancel()
 		}
+		q.mutex.Unlock()
+		return nil
+	}
+	q.unschedule(task)
+	delete(q.tasks, id)
+	q.releaseUnique(task)
+	task.done = true
+	task.err = ErrTaskCancelled
+	if err := q.persist(task); err != nil {
+		log.Printf("Failed to persist task %s: %v", task.id, err)
 	}
-	q.tasks = newTasks
+	after := task.after
+	task.after = nil
 	q.mutex.Unlock()
 
-	q.next = next
-	return len(newTasks) != 0
+	// The 'After' function runs without the mutex, as it may enqueue tasks
+	if after != nil {
+		after(context.Background(), task)
+	}
+	return nil
+}
+
+// unschedule Removes a task which isn't running from whichever heap holds it.
+// Must be called with the mutex held.
+func (q *Queue) unschedule(task *Task) {
+	i := task.heapIndex
+	if i < 0 {
+		return
+	}
+	if i < len(q.scheduled) && q.scheduled[i] == task {
+		heap.Remove(&q.scheduled, i)
+		return
+	}
+	share := q.shareOf(task)
+	if ready, ok := q.ready[share]; ok && i < ready.Len() && (*ready)[i] == task {
+		heap.Remove(ready, i)
+		if ready.Len() == 0 {
+			delete(q.ready, share)
+		}
+	}
+}
+
+// reload Adds the pending tasks found in the store to the queue, keeping their
+// attempt counts and schedule.
+func (q *Queue) reload() error {
+	if q.store == nil {
+		return nil
+	}
+	records, err := q.store.Load(q.name)
+	if err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, record := range records {
+		if _, ok := q.tasks[record.ID]; ok {
+			continue
+		}
+		t, err := taskFromRecord(record)
+		if err == nil {
+			err = q.resolve(t)
+		}
+		if err != nil {
+			log.Printf("Failed to reload task %s: %v", record.ID, err)
+			continue
+		}
+		if t.unique != "" {
+			q.uniques[t.unique] = &uniqueEntry{id: t.id}
+		}
+		// Reloaded tasks age from the restart, like newly enqueued ones
+		t.enqueuedAt = q.now()
+		q.schedule(t)
+	}
+	return nil
 }
 
 func (q *Queue) run(ctx context.Context) {
@@ -119,8 +652,13 @@ func (q *Queue) run(ctx context.Context) {
 		panic(errors.New("this queue is already running on another goroutine"))
 	}
 
-	q.wake = make(chan struct{})
+	q.wake = make(chan struct{}, 1)
 	q.mutex.Unlock()
+	defer q.inflight.Wait()
+
+	if err := q.reload(); err != nil {
+		log.Printf("Failed to reload tasks for queue %s: %v", q.name, err)
+	}
 
 	for {
 		more := q.Dispatch(ctx)
@@ -128,8 +666,12 @@ func (q *Queue) run(ctx context.Context) {
 			return
 		}
 
+		q.mutex.Lock()
+		wait := q.next.Sub(q.now())
+		q.mutex.Unlock()
+
 		select {
-		case <-time.After(q.next.Sub(q.now())):
+		case <-time.After(wait):
 			break
 		case <-ctx.Done():
 			return
@@ -169,7 +711,8 @@ func (q *Queue) Start(ctx context.Context) {
 }
 
 // Shutdown Stops accepting new tasks and blocks until all already-queued tasks are
-// complete. The queue must have been started with Start, not Run.
+// complete, including attempts still running on workers. The queue must have
+// been started with Start, not Run.
 func (q *Queue) Shutdown() {
 	select {
 	case <-q.started:
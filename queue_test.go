@@ -0,0 +1,648 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueue_PersistentReload(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	fail := errors.New("downstream unavailable")
+	RegisterTaskType("test.persist", func(ctx context.Context, payload []byte) error {
+		if string(payload) != "job-1" {
+			t.Errorf("unexpected payload %q", payload)
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return fail
+		}
+		return nil
+	})
+
+	q1 := NewQueue("persist")
+	q1.Storage(store)
+	if err := q1.Enqueue(NewTypedTask("test.persist", []byte("job-1")).Retries(3)); err != nil {
+		t.Fatal(err)
+	}
+	q1.Dispatch(context.Background())
+	q1.inflight.Wait()
+	records, err := store.Load("persist")
+	if err != nil || len(records) != 1 || records[0].Attempts != 1 {
+		t.Fatalf("expected one stored task with one attempt, got %+v (%v)", records, err)
+	}
+
+	// Simulate a restart: a new queue on the same store, with the clock moved
+	// past the scheduled retry.
+	q2 := NewQueue("persist")
+	q2.Storage(store)
+	q2.Now(func() time.Time {
+		return time.Now().UTC().Add(time.Hour)
+	})
+	q2.Start(context.Background())
+	q2.Shutdown()
+
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected the task to be retried once after reload, got %d calls", n)
+	}
+	if records, _ := store.Load("persist"); len(records) != 0 {
+		t.Fatalf("expected completed task to be removed from the store, got %+v", records)
+	}
+}
+
+func TestQueue_Workers(t *testing.T) {
+	q := NewQueue("workers")
+	q.Workers(4)
+	var done int32
+	release := make(chan struct{})
+	nested := make(chan struct{}, 4)
+	for i := 0; i < 4; i++ {
+		_, err := q.Submit(func(ctx context.Context) error {
+			// Every task blocks until all of them are running at once
+			if atomic.AddInt32(&done, 1) == 4 {
+				close(release)
+			}
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+				return errors.New("tasks did not run concurrently")
+			}
+			// Re-entrant submission must not deadlock
+			_, err := q.Submit(func(ctx context.Context) error {
+				atomic.AddInt32(&done, 1)
+				nested <- struct{}{}
+				return nil
+			})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Start(context.Background())
+	for i := 0; i < 4; i++ {
+		select {
+		case <-nested:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for nested tasks")
+		}
+	}
+	q.Shutdown()
+	if n := atomic.LoadInt32(&done); n != 8 {
+		t.Fatalf("expected 8 completed tasks, got %d", n)
+	}
+}
+
+func TestQueue_PriorityAndFairShare(t *testing.T) {
+	q := NewQueue("priority")
+	q.FairShare("tenant")
+	var order []string
+	newTask := func(name, tenant string, priority int) *Task {
+		task := NewTask(func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}).Priority(priority)
+		task.Metadata["tenant"] = tenant
+		return task
+	}
+	for _, task := range []*Task{
+		newTask("a-low", "a", 0),
+		newTask("a-high", "a", 5),
+		newTask("a-mid", "a", 2),
+		newTask("b-low", "b", 0),
+	} {
+		if err := q.Enqueue(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	expected := []string{"a-high", "b-low", "a-mid", "a-low"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, order)
+		}
+	}
+}
+
+func TestQueue_Aging(t *testing.T) {
+	var mutex sync.Mutex
+	now := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	}
+	var order []string
+	RegisterTaskType("test.aging", func(ctx context.Context, payload []byte) error {
+		order = append(order, string(payload))
+		return nil
+	})
+	drain := func(q *Queue) {
+		for q.Dispatch(context.Background()) {
+			q.inflight.Wait()
+		}
+	}
+
+	// A task waiting for three aging periods overtakes a newer task two
+	// priority levels higher
+	for _, aging := range []time.Duration{time.Minute, 0} {
+		q := NewQueue("aging")
+		q.Now(clock)
+		q.Aging(aging)
+		if err := q.Enqueue(NewTypedTask("test.aging", []byte("old"))); err != nil {
+			t.Fatal(err)
+		}
+		mutex.Lock()
+		now = now.Add(3 * time.Minute)
+		mutex.Unlock()
+		if err := q.Enqueue(NewTypedTask("test.aging", []byte("new")).Priority(2)); err != nil {
+			t.Fatal(err)
+		}
+		order = nil
+		drain(q)
+		expected := []string{"old", "new"}
+		if aging == 0 {
+			expected = []string{"new", "old"}
+		}
+		if !reflect.DeepEqual(order, expected) {
+			t.Errorf("aging %s: expected %v, got %v", aging, expected, order)
+		}
+	}
+
+	// A reloaded task ages from the restart, so it doesn't overtake tasks of
+	// a higher priority
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q1 := NewQueue("aging-reload")
+	q1.Now(clock)
+	q1.Storage(store)
+	if err := q1.Enqueue(NewTypedTask("test.aging", []byte("reloaded"))); err != nil {
+		t.Fatal(err)
+	}
+	q2 := NewQueue("aging-reload")
+	q2.Now(clock)
+	q2.Storage(store)
+	if err := q2.Enqueue(NewTypedTask("test.aging", []byte("urgent")).Priority(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q2.reload(); err != nil {
+		t.Fatal(err)
+	}
+	order = nil
+	drain(q2)
+	if expected := []string{"urgent", "reloaded"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected %v after reload, got %v", expected, order)
+	}
+}
+
+// sliceQueue Reproduces the scheduler Queue used before tasks were kept in a
+// heap: every dispatch copies and scans the whole task list.
+type sliceQueue struct {
+	mutex sync.Mutex
+	tasks []*Task
+}
+
+func (q *sliceQueue) Dispatch(ctx context.Context) bool {
+	now := time.Now().UTC()
+	q.mutex.Lock()
+	tasks := make([]*Task, len(q.tasks))
+	copy(tasks, q.tasks)
+	q.mutex.Unlock()
+
+	for _, task := range tasks {
+		if task.NextAttempt().Before(now) {
+			_, _ = task.Attempt(ctx)
+		}
+	}
+
+	q.mutex.Lock()
+	newTasks := make([]*Task, 0, len(q.tasks))
+	for _, task := range q.tasks {
+		if !task.Done() {
+			newTasks = append(newTasks, task)
+		}
+	}
+	q.tasks = newTasks
+	q.mutex.Unlock()
+	return len(newTasks) != 0
+}
+
+func noop(ctx context.Context) error {
+	return nil
+}
+
+func delayedTasks(n int) []*Task {
+	later := time.Now().Add(time.Hour)
+	tasks := make([]*Task, n)
+	for i := range tasks {
+		tasks[i] = NewTask(noop).NotBefore(later)
+	}
+	return tasks
+}
+
+// BenchmarkQueue_Dispatch Measures dispatching one due task while many delayed
+// retries are waiting in the queue.
+func BenchmarkQueue_Dispatch(b *testing.B) {
+	for _, delayed := range []int{1000, 10000, 50000} {
+		b.Run(fmt.Sprintf("heap/%d", delayed), func(b *testing.B) {
+			q := NewQueue("bench")
+			for _, t := range delayedTasks(delayed) {
+				_ = q.Enqueue(t)
+			}
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = q.Enqueue(NewTask(noop))
+				q.Dispatch(ctx)
+				q.inflight.Wait()
+			}
+		})
+		b.Run(fmt.Sprintf("slice/%d", delayed), func(b *testing.B) {
+			q := &sliceQueue{tasks: delayedTasks(delayed)}
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				q.mutex.Lock()
+				q.tasks = append(q.tasks, NewTask(noop))
+				q.mutex.Unlock()
+				q.Dispatch(ctx)
+			}
+		})
+	}
+}
+
+func TestQueue_DeadLetters(t *testing.T) {
+	q := NewQueue("dead")
+	q.Now(func() time.Time {
+		return time.Now().UTC().Add(time.Hour)
+	})
+	var calls int32
+	task := NewTask(func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			return errors.New("boom")
+		}
+		return nil
+	}).Retries(2)
+	if err := q.Enqueue(task); err != nil {
+		t.Fatal(err)
+	}
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	letters, err := q.DeadLetters()
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("expected one dead letter, got %+v (%v)", letters, err)
+	}
+	if letters[0].LastError != "boom" || len(letters[0].Task.History) != 2 {
+		t.Fatalf("expected the last error and attempt history, got %+v", letters[0])
+	}
+
+	if err := q.Redrive(letters[0].Task.ID); err != nil {
+		t.Fatal(err)
+	}
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	if task.Result() != nil || task.Attempts() != 1 {
+		t.Fatalf("expected re-driven task to succeed on its first attempt, got %v after %d", task.Result(), task.Attempts())
+	}
+	if letters, _ := q.DeadLetters(); len(letters) != 0 {
+		t.Fatalf("expected no dead letters after re-drive, got %+v", letters)
+	}
+}
+
+func TestQueue_RedriveUntyped(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewQueue("dead-untyped")
+	q.DeadLetterStorage(store)
+	q.Now(func() time.Time {
+		return time.Now().UTC().Add(time.Hour)
+	})
+	task := NewTask(func(ctx context.Context) error {
+		return errors.New("boom")
+	}).WithID("untyped")
+	if err := q.Enqueue(task); err != nil {
+		t.Fatal(err)
+	}
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	if err := q.Redrive("untyped"); !errors.Is(err, ErrNotRedrivable) {
+		t.Fatalf("expected ErrNotRedrivable, got %v", err)
+	}
+	if letters, _ := q.DeadLetters(); len(letters) != 1 {
+		t.Fatalf("expected the dead letter to be kept, got %+v", letters)
+	}
+	if n := len(q.Tasks()); n != 0 {
+		t.Fatalf("expected nothing to be enqueued, got %d tasks", n)
+	}
+}
+
+func TestQueue_RedriveAll(t *testing.T) {
+	q := NewQueue("dead-all")
+	q.Now(func() time.Time {
+		return time.Now().UTC().Add(time.Hour)
+	})
+	var tasks []*Task
+	for i := 0; i < 3; i++ {
+		var calls int32
+		task := NewTask(func(ctx context.Context) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return errors.New("boom")
+			}
+			return nil
+		})
+		if err := q.Enqueue(task); err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	if letters, _ := q.DeadLetters(); len(letters) != 3 {
+		t.Fatalf("expected three dead letters, got %+v", letters)
+	}
+
+	n, err := q.RedriveAll()
+	if err != nil || n != 3 {
+		t.Fatalf("expected three tasks to be re-driven, got %d (%v)", n, err)
+	}
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	for _, task := range tasks {
+		if task.Result() != nil {
+			t.Fatalf("expected re-driven task to succeed, got %v", task.Result())
+		}
+	}
+	if letters, _ := q.DeadLetters(); len(letters) != 0 {
+		t.Fatalf("expected no dead letters after re-drive, got %+v", letters)
+	}
+}
+
+func TestQueue_InspectAndCancel(t *testing.T) {
+	q := NewQueue("cancel")
+	q.Workers(2)
+	started := make(chan struct{})
+	running := NewTask(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}).WithID("running").Retries(5).After(func(ctx context.Context, task *Task) {
+		// Cancelling again while the cancellation finishes must not panic
+		if err := q.Cancel("running"); err != nil {
+			t.Error(err)
+		}
+	})
+	var after error
+	pending := NewTask(noop).WithID("pending").NotBefore(time.Now().Add(time.Hour)).After(func(ctx context.Context, task *Task) {
+		after = task.Result()
+	})
+	for _, task := range []*Task{running, pending} {
+		if err := q.Enqueue(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Enqueue(NewTask(noop).WithID("pending")); !errors.Is(err, ErrDuplicateTaskID) {
+		t.Fatalf("expected ErrDuplicateTaskID, got %v", err)
+	}
+	q.Start(context.Background())
+	<-started
+
+	tasks := q.Tasks()
+	if len(tasks) != 2 || tasks[1].ID != "pending" {
+		t.Fatalf("expected two tasks ordered by next attempt, got %+v", tasks)
+	}
+	if info, err := q.Get("running"); err != nil || !info.Running || info.Attempts != 1 {
+		t.Fatalf("expected a running task with one attempt, got %+v (%v)", info, err)
+	}
+
+	if err := q.Cancel("pending"); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(after, ErrTaskCancelled) {
+		t.Fatalf("expected the After function to see ErrTaskCancelled, got %v", after)
+	}
+	if err := q.Cancel("running"); err != nil {
+		t.Fatal(err)
+	}
+	q.Shutdown()
+	if !errors.Is(running.Result(), ErrTaskCancelled) || running.Attempts() != 1 {
+		t.Fatalf("expected the running task to be cancelled after one attempt, got %v", running.Result())
+	}
+	if _, err := q.Get("running"); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestQueue_Unique(t *testing.T) {
+	q := NewQueue("unique")
+	q.UniqueWindow(time.Hour)
+	first := NewTask(noop).Unique("webhook-42")
+	if err := q.Enqueue(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(NewTask(noop).Unique("webhook-42")); !errors.Is(err, ErrDuplicateTask) {
+		t.Fatalf("expected ErrDuplicateTask while pending, got %v", err)
+	}
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	if err := q.Enqueue(NewTask(noop).Unique("webhook-42")); !errors.Is(err, ErrDuplicateTask) {
+		t.Fatalf("expected ErrDuplicateTask within the window, got %v", err)
+	}
+
+	q.OnDuplicate(DuplicateMerge)
+	second := NewTask(noop).Unique("webhook-43").NotBefore(time.Now().Add(time.Hour))
+	if err := q.Enqueue(second); err != nil {
+		t.Fatal(err)
+	}
+	duplicate := NewTask(noop).Unique("webhook-43")
+	duplicate.Metadata["retry_of"] = "delivery-1"
+	if err := q.Enqueue(duplicate); err != nil {
+		t.Fatalf("expected merged duplicate to be accepted, got %v", err)
+	}
+	if tasks := q.Tasks(); len(tasks) != 1 || tasks[0].Metadata["retry_of"] != "delivery-1" {
+		t.Fatalf("expected the duplicate to be merged into the pending task, got %+v", tasks)
+	}
+
+	// Merged metadata is persisted, even into a task without metadata
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterTaskType("test.unique", func(ctx context.Context, payload []byte) error {
+		return nil
+	})
+	stored := NewQueue("unique-stored")
+	stored.Storage(store)
+	stored.OnDuplicate(DuplicateMerge)
+	pending := NewTypedTask("test.unique", nil).Unique("webhook-44").NotBefore(time.Now().Add(time.Hour))
+	pending.Metadata = nil
+	if err := stored.Enqueue(pending); err != nil {
+		t.Fatal(err)
+	}
+	duplicate = NewTypedTask("test.unique", nil).Unique("webhook-44")
+	duplicate.Metadata["retry_of"] = "delivery-2"
+	if err := stored.Enqueue(duplicate); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.Load("unique-stored")
+	if err != nil || len(records) != 1 || records[0].Metadata["retry_of"] != "delivery-2" {
+		t.Fatalf("expected the merged metadata to be stored, got %+v (%v)", records, err)
+	}
+}
+
+func TestQueue_RateLimit(t *testing.T) {
+	q := NewQueue("limited")
+	now := time.Now().UTC()
+	q.Now(func() time.Time {
+		return now
+	})
+	q.Workers(10)
+	q.RateLimit(2, 2)
+	q.KeyMaxInFlight("host", 1)
+	var calls int32
+	for i := 0; i < 5; i++ {
+		task := NewTask(func(ctx context.Context) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+		task.Metadata["host"] = fmt.Sprintf("api-%d", i%3)
+		if err := q.Enqueue(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Dispatch(context.Background())
+	q.inflight.Wait()
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected a burst of 2 attempts, got %d", n)
+	}
+	if wait := q.next.Sub(now); wait <= 0 || wait > time.Second/2 {
+		t.Fatalf("expected the next dispatch within half a second, got %s", wait)
+	}
+
+	now = now.Add(time.Second)
+	q.Dispatch(context.Background())
+	q.inflight.Wait()
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Fatalf("expected 2 more attempts after a second, got %d", n)
+	}
+	for _, info := range q.Tasks() {
+		if info.Attempts != 0 {
+			t.Fatalf("expected rate limited tasks to keep their attempts, got %+v", info)
+		}
+	}
+}
+
+func TestQueue_KeyMaxInFlight(t *testing.T) {
+	q := NewQueue("capped")
+	q.Workers(10)
+	q.KeyMaxInFlight("host", 2)
+	var mutex sync.Mutex
+	running, peak := make(map[string]int), make(map[string]int)
+	started := make(chan string, 6)
+	gate := make(chan struct{})
+	for i, host := range []string{"a", "a", "a", "a", "a", "b"} {
+		host := host
+		task := NewTask(func(ctx context.Context) error {
+			mutex.Lock()
+			if running[host]++; running[host] > peak[host] {
+				peak[host] = running[host]
+			}
+			mutex.Unlock()
+			started <- host
+			<-gate
+			mutex.Lock()
+			running[host]--
+			mutex.Unlock()
+			return nil
+		}).WithID(fmt.Sprintf("task-%d", i))
+		task.Metadata["host"] = host
+		if err := q.Enqueue(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The cap of "a" is reached while "b" still starts
+	q.Dispatch(context.Background())
+	counts := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		counts[<-started]++
+	}
+	if counts["a"] != 2 || counts["b"] != 1 {
+		t.Fatalf("expected 2 attempts of a and 1 of b, got %v", counts)
+	}
+	select {
+	case host := <-started:
+		t.Fatalf("expected no more attempts, got one of %s", host)
+	default:
+	}
+
+	close(gate)
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	if len(started) != 3 || peak["a"] != 2 || peak["b"] != 1 {
+		t.Fatalf("expected the remaining attempts within the cap, got %d more and peaks %v", len(started), peak)
+	}
+}
+
+func TestQueue_KeyRateLimit(t *testing.T) {
+	q := NewQueue("key-limited")
+	now := time.Now().UTC()
+	q.Now(func() time.Time {
+		return now
+	})
+	q.Workers(10)
+	q.KeyRateLimit("tenant", 1, 1)
+	var mutex sync.Mutex
+	ran := make(map[string]bool)
+	for _, id := range []string{"a-1", "a-2", "b-1"} {
+		id := id
+		task := NewTask(func(ctx context.Context) error {
+			mutex.Lock()
+			ran[id] = true
+			mutex.Unlock()
+			return nil
+		}).WithID(id)
+		task.Metadata["tenant"] = id[:1]
+		if err := q.Enqueue(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second task of tenant a waits for a token, b isn't delayed
+	q.Dispatch(context.Background())
+	q.inflight.Wait()
+	if !ran["a-1"] || !ran["b-1"] || ran["a-2"] {
+		t.Fatalf("expected a-1 and b-1 to run, got %v", ran)
+	}
+	tasks := q.Tasks()
+	if len(tasks) != 1 || tasks[0].ID != "a-2" || tasks[0].Attempts != 0 || !tasks[0].NextAttempt.Equal(now.Add(time.Second)) {
+		t.Fatalf("expected a-2 to be rescheduled a second later without an attempt, got %+v", tasks)
+	}
+
+	now = now.Add(time.Second + time.Millisecond)
+	q.Dispatch(context.Background())
+	q.inflight.Wait()
+	if !ran["a-2"] {
+		t.Fatal("expected a-2 to run once a token is available")
+	}
+}
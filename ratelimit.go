@@ -0,0 +1,156 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TokenBucket Rate limiter which allows bursts of up to burst events and
+// refills at rate events per second. It isn't safe for concurrent use; queues
+// guard their buckets with their own mutex.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket Creates a full token bucket.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 || burst < 1 {
+		panic(errors.New("invalid input to NewTokenBucket"))
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// Wait Returns how long until a token is available, or zero if one is
+// available now.
+func (b *TokenBucket) Wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Take Takes a token if one is available and reports whether it did.
+func (b *TokenBucket) Take(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full Reports whether the bucket has refilled completely, so it can be
+// dropped and recreated without changing behaviour.
+func (b *TokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// RateLimit Limits how many attempts the queue starts per second, allowing
+// bursts of up to burst attempts. Due tasks wait for a token without consuming
+// a retry attempt. A rate of zero removes the limit.
+func (q *Queue) RateLimit(perSecond float64, burst int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.limiter = nil
+	if perSecond > 0 {
+		q.limiter = NewTokenBucket(perSecond, burst)
+	}
+}
+
+// KeyRateLimit Limits how many attempts the queue starts per second for each
+// value of a metadata key, such as a tenant or API host. Tasks over the limit
+// are rescheduled for when a token is available, without consuming a retry
+// attempt. A rate of zero removes the limit.
+func (q *Queue) KeyRateLimit(metadataKey string, perSecond float64, burst int) {
+	if perSecond > 0 && burst < 1 {
+		panic(errors.New("invalid input to Queue.KeyRateLimit"))
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.rateKey, q.keyRate, q.keyBurst = metadataKey, perSecond, burst
+	q.keyLimiters = make(map[string]*TokenBucket)
+	if perSecond <= 0 {
+		q.rateKey = ""
+	}
+}
+
+// KeyMaxInFlight Caps how many attempts may run at once for each value of a
+// metadata key. Tasks over the cap wait until an attempt with the same value
+// finishes. The number of attempts in flight across the whole queue is capped
+// by Workers. Zero removes the cap.
+func (q *Queue) KeyMaxInFlight(metadataKey string, n int) {
+	if n < 0 {
+		panic(errors.New("invalid input to Queue.KeyMaxInFlight"))
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.inFlightKey, q.keyInFlight = metadataKey, n
+	if n == 0 {
+		q.inFlightKey = ""
+	}
+}
+
+func metadataValue(task *Task, key string) string {
+	return fmt.Sprint(task.Metadata[key])
+}
+
+// admit Decides whether a ready task may start now. It returns start=false and
+// a zero wait if the task has to wait for its key's in-flight cap, and a
+// non-zero wait if its key is rate limited. Must be called with the mutex held
+// after checking the queue-wide limiter.
+func (q *Queue) admit(task *Task, now time.Time) (bool, time.Duration) {
+	if q.inFlightKey != "" && q.keyActive[metadataValue(task, q.inFlightKey)] >= q.keyInFlight {
+		return false, 0
+	}
+	if q.rateKey != "" {
+		value := metadataValue(task, q.rateKey)
+		bucket, ok := q.keyLimiters[value]
+		if !ok {
+			bucket = NewTokenBucket(q.keyRate, q.keyBurst)
+			q.keyLimiters[value] = bucket
+		}
+		if wait := bucket.Wait(now); wait > 0 {
+			return false, wait
+		}
+		bucket.Take(now)
+	}
+	if q.limiter != nil {
+		q.limiter.Take(now)
+	}
+	return true, 0
+}
+
+// pruneLimiters Drops per-key buckets which have refilled completely. Must be
+// called with the mutex held.
+func (q *Queue) pruneLimiters(now time.Time) {
+	if len(q.keyLimiters) < 1024 {
+		return
+	}
+	for value, bucket := range q.keyLimiters {
+		if bucket.full(now) {
+			delete(q.keyLimiters, value)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrJobExists Returned when adding a recurring job under a name which is
+// already scheduled.
+var ErrJobExists = errors.New("a recurring job with this name already exists")
+
+// OverlapPolicy Decides what happens when a recurring job is due while its
+// previous run hasn't finished.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip Skips the activation.
+	OverlapSkip OverlapPolicy = iota
+
+	// OverlapQueue Runs the activation once the previous run has finished.
+	OverlapQueue
+
+	// OverlapAllow Runs the activation concurrently with the previous run.
+	OverlapAllow
+)
+
+// CatchUpPolicy Decides what happens to activations missed while the
+// scheduler wasn't running.
+type CatchUpPolicy int
+
+const (
+	// CatchUpNone Ignores missed activations.
+	CatchUpNone CatchUpPolicy = iota
+
+	// CatchUpOnce Runs the job once if any activation was missed.
+	CatchUpOnce
+
+	// CatchUpAll Runs the job once for every missed activation, up to
+	// MaxCatchUp runs.
+	CatchUpAll
+)
+
+// MaxCatchUp Upper limit of runs enqueued by CatchUpAll for a single job.
+const MaxCatchUp = 1000
+
+// ScheduleStore Remembers when recurring jobs last ran, so activations missed
+// during downtime can be caught up.
+type ScheduleStore interface {
+	LastRun(name string) (time.Time, error)
+	SetLastRun(name string, t time.Time) error
+}
+
+// RecurringJob Describes a job which is enqueued on a queue at every activation
+// of its schedule.
+type RecurringJob struct {
+	Name     string
+	Schedule Schedule
+	Fn       TaskFunc
+	Overlap  OverlapPolicy
+	CatchUp  CatchUpPolicy
+
+	// LastRun When the job last ran, used for catch-up if the scheduler has no
+	// ScheduleStore.
+	LastRun time.Time
+
+	// Configure Optionally customizes the task created for every run, e.g.
+	// with Retries or Within.
+	Configure func(t *Task)
+}
+
+type recurringJob struct {
+	RecurringJob
+	tick    string
+	next    time.Time
+	running int
+	queued  int
+}
+
+// Scheduler Enqueues recurring jobs on a queue according to their schedules.
+// Every activation is a short internal task which enqueues the run and
+// schedules the next activation, so runs get the queue's retries, priorities
+// and worker limits.
+type Scheduler struct {
+	mutex sync.Mutex
+	queue *Queue
+	store ScheduleStore
+	jobs  map[string]*recurringJob
+}
+
+// NewScheduler Creates a scheduler for recurring jobs on the given queue.
+func NewScheduler(q *Queue) *Scheduler {
+	return &Scheduler{
+		queue: q,
+		jobs:  make(map[string]*recurringJob),
+	}
+}
+
+// Storage Sets the store used to remember when jobs last ran.
+func (s *Scheduler) Storage(store ScheduleStore) {
+	s.mutex.Lock()
+	s.store = store
+	s.mutex.Unlock()
+}
+
+// Add Schedules a recurring job, first catching up on activations missed since
+// it last ran according to its CatchUp policy.
+func (s *Scheduler) Add(job RecurringJob) error {
+	if job.Name == "" || job.Schedule == nil || job.Fn == nil {
+		return errors.New("recurring job requires a name, a schedule and a function")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.jobs[job.Name]; ok {
+		return fmt.Errorf("%w: '%s'", ErrJobExists, job.Name)
+	}
+	j := &recurringJob{RecurringJob: job}
+	now := s.queue.now()
+
+	lastRun := job.LastRun
+	if s.store != nil {
+		stored, err := s.store.LastRun(job.Name)
+		if err != nil {
+			return err
+		}
+		if stored.After(lastRun) {
+			lastRun = stored
+		}
+	}
+	if !lastRun.IsZero() && job.CatchUp != CatchUpNone {
+		missed := 0
+		for t := job.Schedule.Next(lastRun); !t.IsZero() && !t.After(now) && missed < MaxCatchUp; t = job.Schedule.Next(t) {
+			missed++
+			if job.CatchUp == CatchUpOnce {
+				break
+			}
+		}
+		for i := 0; i < missed; i++ {
+			if err := s.enqueueRun(j); err != nil {
+				return err
+			}
+		}
+		if missed > 0 {
+			s.setLastRun(job.Name, now)
+		}
+	}
+
+	s.jobs[job.Name] = j
+	return s.scheduleTick(j, job.Schedule.Next(now))
+}
+
+// AddFlow Schedules a recurring execution of a registered flow with a static
+// payload. The registry defaults to DefaultRegistry.
+func (s *Scheduler) AddFlow(job RecurringJob, registry *Registry, namespace, key string, data Data) error {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	job.Fn = func(ctx context.Context) error {
+		_, err := registry.Execute(ctx, namespace, key, data)
+		return err
+	}
+	return s.Add(job)
+}
+
+// Remove Stops scheduling a job. Runs which were already enqueued continue.
+func (s *Scheduler) Remove(name string) bool {
+	s.mutex.Lock()
+	j, ok := s.jobs[name]
+	if ok {
+		delete(s.jobs, name)
+	}
+	s.mutex.Unlock()
+	if ok && j.tick != "" {
+		_ = s.queue.Cancel(j.tick)
+	}
+	return ok
+}
+
+// Next Returns the next activation time of a job.
+func (s *Scheduler) Next(name string) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	return j.next, true
+}
+
+// Jobs Returns the sorted names of all scheduled jobs.
+func (s *Scheduler) Jobs() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Scheduler) setLastRun(name string, t time.Time) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.SetLastRun(name, t); err != nil {
+		log.Printf("Failed to record last run of job %s: %v", name, err)
+	}
+}
+
+// scheduleTick Enqueues the activation of a job at the given time. Must be
+// called with the mutex held.
+func (s *Scheduler) scheduleTick(j *recurringJob, at time.Time) error {
+	j.next = at
+	j.tick = ""
+	if at.IsZero() {
+		return nil
+	}
+	tick := NewTask(func(ctx context.Context) error {
+		s.activate(j, at)
+		return nil
+	}).WithID(fmt.Sprintf("schedule:%s:%d", j.Name, at.UnixNano())).NotBefore(at)
+	if err := s.queue.Enqueue(tick); err != nil {
+		return err
+	}
+	j.tick = tick.ID()
+	return nil
+}
+
+// activate Runs an activation of a job: it schedules the next activation and
+// enqueues a run according to the overlap policy.
+func (s *Scheduler) activate(j *recurringJob, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.jobs[j.Name] != j {
+		return
+	}
+	now := s.queue.now()
+	next := j.Schedule.Next(at)
+	if !next.IsZero() && next.Before(now) {
+		// Activations missed while the queue was busy aren't caught up
+		next = j.Schedule.Next(now)
+	}
+	if err := s.scheduleTick(j, next); err != nil {
+		log.Printf("Failed to schedule job %s: %v", j.Name, err)
+	}
+	s.setLastRun(j.Name, at)
+
+	switch {
+	case j.running == 0 || j.Overlap == OverlapAllow:
+		if err := s.enqueueRun(j); err != nil {
+			log.Printf("Failed to enqueue job %s: %v", j.Name, err)
+		}
+	case j.Overlap == OverlapQueue:
+		j.queued++
+	}
+}
+
+// enqueueRun Enqueues a run of a job. Must be called with the mutex held.
+func (s *Scheduler) enqueueRun(j *recurringJob) error {
+	t := NewTask(j.Fn)
+	t.Metadata["schedule"] = j.Name
+	if j.Configure != nil {
+		j.Configure(t)
+	}
+	after := t.after
+	t.after = func(ctx context.Context, task *Task) {
+		if after != nil {
+			after(ctx, task)
+		}
+		s.finishRun(j)
+	}
+	j.running++
+	if err := s.queue.Enqueue(t); err != nil {
+		j.running--
+		return err
+	}
+	return nil
+}
+
+// finishRun Starts a queued run once the previous one has finished.
+func (s *Scheduler) finishRun(j *recurringJob) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j.running--
+	if j.queued > 0 && j.running == 0 {
+		j.queued--
+		if err := s.enqueueRun(j); err != nil {
+			log.Printf("Failed to enqueue job %s: %v", j.Name, err)
+		}
+	}
+}
+
+// LastRun Implements ScheduleStore.
+func (s *FileStore) LastRun(name string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var t time.Time
+	b, err := os.ReadFile(s.path("_schedules", name+".json"))
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	return t, json.Unmarshal(b, &t)
+}
+
+// SetLastRun Implements ScheduleStore.
+func (s *FileStore) SetLastRun(name string, t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(s.path("_schedules", name+".json"), t)
+}
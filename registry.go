@@ -0,0 +1,270 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultNamespace Namespace used for flows which don't declare one.
+const DefaultNamespace = "default"
+
+// Version states of a registered flow.
+const (
+	// VersionActive The version can be resolved as latest and by number.
+	VersionActive = "ACTIVE"
+
+	// VersionDeprecated The version is no longer resolved as latest, but pinned
+	// executions can still resolve it by number.
+	VersionDeprecated = "DEPRECATED"
+
+	// VersionRetired The version can't be resolved at all.
+	VersionRetired = "RETIRED"
+)
+
+var (
+	// ErrFlowExists Returned when a different flow is already registered under the
+	// same key and version.
+	ErrFlowExists = errors.New("a flow with this key and version is already registered")
+
+	// ErrFlowKeyRequired Returned when registering a flow without a key.
+	ErrFlowKeyRequired = errors.New("flow key is required for registration")
+
+	// ErrFlowNotFound Returned when no flow matches the requested key and version.
+	ErrFlowNotFound = errors.New("flow not found")
+
+	// ErrFlowRetired Returned when the requested flow version has been retired.
+	ErrFlowRetired = errors.New("flow version has been retired")
+
+	// DefaultRegistry Registry used by Add, Get, All and by flows built without
+	// an explicit registry.
+	DefaultRegistry = NewRegistry()
+)
+
+// FlowVersion Describes one registered version of a flow.
+type FlowVersion struct {
+	Version int    `json:"version"`
+	State   string `json:"state"`
+	Flow    *Flow  `json:"-"`
+}
+
+// Registry Stores built flows by namespace, key and version. It is safe for
+// concurrent use.
+type Registry struct {
+	mutex sync.RWMutex
+	flows map[string]map[string][]*FlowVersion
+}
+
+// NewRegistry Creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		flows: make(map[string]map[string][]*FlowVersion),
+	}
+}
+
+func namespaceOf(namespace string) string {
+	if namespace == "" {
+		return DefaultNamespace
+	}
+	return namespace
+}
+
+// Register Adds a flow under the given namespace and key. If the flow has no
+// Version, it is assigned the next version number for the key. Registering the
+// same flow twice is a no-op; registering a different flow under an existing
+// version returns ErrFlowExists.
+func (r *Registry) Register(namespace, key string, flow *Flow) error {
+	if key == "" {
+		return ErrFlowKeyRequired
+	}
+	namespace = namespaceOf(namespace)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	flows, ok := r.flows[namespace]
+	if !ok {
+		flows = make(map[string][]*FlowVersion)
+		r.flows[namespace] = flows
+	}
+	versions := flows[key]
+	latest := 0
+	for _, v := range versions {
+		if v.Flow == flow {
+			return nil
+		}
+		if v.Version == flow.Version {
+			return fmt.Errorf("%w: '%s' version %d in namespace '%s'", ErrFlowExists, key, flow.Version, namespace)
+		}
+		if v.Version > latest {
+			latest = v.Version
+		}
+	}
+	if flow.Version == 0 {
+		flow.Version = latest + 1
+	}
+	versions = append(versions, &FlowVersion{
+		Version: flow.Version,
+		State:   VersionActive,
+		Flow:    flow,
+	})
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	flows[key] = versions
+	return nil
+}
+
+// Get Returns the latest active version of a flow, or nil.
+func (r *Registry) Get(namespace, key string) *Flow {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	versions := r.flows[namespaceOf(namespace)][key]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].State == VersionActive {
+			return versions[i].Flow
+		}
+	}
+	return nil
+}
+
+// GetVersion Returns a specific version of a flow. Deprecated versions are
+// still returned; retired versions return ErrFlowRetired. A version of zero
+// resolves to the latest active version.
+func (r *Registry) GetVersion(namespace, key string, version int) (*Flow, error) {
+	if version == 0 {
+		if f := r.Get(namespace, key); f != nil {
+			return f, nil
+		}
+		return nil, fmt.Errorf("%w: '%s' in namespace '%s'", ErrFlowNotFound, key, namespaceOf(namespace))
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, v := range r.flows[namespaceOf(namespace)][key] {
+		if v.Version != version {
+			continue
+		}
+		if v.State == VersionRetired {
+			return nil, fmt.Errorf("%w: '%s' version %d", ErrFlowRetired, key, version)
+		}
+		return v.Flow, nil
+	}
+	return nil, fmt.Errorf("%w: '%s' version %d in namespace '%s'", ErrFlowNotFound, key, version, namespaceOf(namespace))
+}
+
+// Versions Returns all registered versions of a flow, oldest first.
+func (r *Registry) Versions(namespace, key string) []FlowVersion {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	versions := r.flows[namespaceOf(namespace)][key]
+	list := make([]FlowVersion, 0, len(versions))
+	for _, v := range versions {
+		list = append(list, *v)
+	}
+	return list
+}
+
+// Deprecate Stops resolving a version as latest while keeping it available to
+// executions pinned to it.
+func (r *Registry) Deprecate(namespace, key string, version int) error {
+	return r.setState(namespace, key, version, VersionDeprecated)
+}
+
+// Retire Makes a version unavailable, including to executions pinned to it.
+func (r *Registry) Retire(namespace, key string, version int) error {
+	return r.setState(namespace, key, version, VersionRetired)
+}
+
+func (r *Registry) setState(namespace, key string, version int, state string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, v := range r.flows[namespaceOf(namespace)][key] {
+		if v.Version == version {
+			v.State = state
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: '%s' version %d in namespace '%s'", ErrFlowNotFound, key, version, namespaceOf(namespace))
+}
+
+// Remove Removes all versions of a flow from the registry. Returns false if it
+// wasn't registered.
+func (r *Registry) Remove(namespace, key string) bool {
+	namespace = namespaceOf(namespace)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	flows, ok := r.flows[namespace]
+	if !ok {
+		return false
+	}
+	if _, ok := flows[key]; !ok {
+		return false
+	}
+	delete(flows, key)
+	if len(flows) == 0 {
+		delete(r.flows, namespace)
+	}
+	return true
+}
+
+// List Returns the sorted keys of all flows in a namespace.
+func (r *Registry) List(namespace string) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	flows := r.flows[namespaceOf(namespace)]
+	keys := make([]string, 0, len(flows))
+	for key := range flows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// All Returns the latest active version of all flows in a namespace, by key.
+func (r *Registry) All(namespace string) map[string]*Flow {
+	all := make(map[string]*Flow)
+	for _, key := range r.List(namespace) {
+		if f := r.Get(namespace, key); f != nil {
+			all[key] = f
+		}
+	}
+	return all
+}
+
+// Namespaces Returns the sorted names of all namespaces with at least one flow.
+func (r *Registry) Namespaces() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	namespaces := make([]string, 0, len(r.flows))
+	for namespace := range r.flows {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// Add Registers a flow in the default namespace of DefaultRegistry.
+func Add(key string, flow *Flow) error {
+	return DefaultRegistry.Register(DefaultNamespace, key, flow)
+}
+
+// Get Returns the latest active version of a flow from the default namespace
+// of DefaultRegistry.
+func Get(key string) *Flow {
+	return DefaultRegistry.Get(DefaultNamespace, key)
+}
+
+// GetVersion Returns a specific version of a flow from the default namespace
+// of DefaultRegistry.
+func GetVersion(key string, version int) (*Flow, error) {
+	return DefaultRegistry.GetVersion(DefaultNamespace, key, version)
+}
+
+// Remove Removes a flow from the default namespace of DefaultRegistry.
+func Remove(key string) bool {
+	return DefaultRegistry.Remove(DefaultNamespace, key)
+}
+
+// All Returns a copy of all flows in the default namespace of DefaultRegistry.
+func All() map[string]*Flow {
+	return DefaultRegistry.All(DefaultNamespace)
+}
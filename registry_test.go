@@ -0,0 +1,124 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func echo(ctx context.Context, d Data) (Data, error) {
+	return d, nil
+}
+
+func TestRegistry_ConcurrentBuild(t *testing.T) {
+	registry := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f := New().WithRegistry(registry)
+			f.Key = fmt.Sprintf("flow-%d", i)
+			f.Namespace = fmt.Sprintf("tenant-%d", i%3)
+			f.AddNode("a", echo).AddNode("b", echo).Edge("a", "b")
+			if f.Build().Error != nil {
+				t.Error(f.Error)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := len(registry.Namespaces()); n != 3 {
+		t.Fatalf("expected 3 namespaces, got %d", n)
+	}
+	if f := registry.Get("tenant-1", "flow-1"); f == nil {
+		t.Fatal("expected flow-1 in tenant-1")
+	}
+}
+
+func TestRegistry_Versions(t *testing.T) {
+	registry := NewRegistry()
+	v1, v2, dup := New(), New(), New()
+	if err := registry.Register("", "orders", v1); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("", "orders", v1); err != nil {
+		t.Fatalf("re-registering the same flow should be a no-op: %v", err)
+	}
+	if err := registry.Register("", "orders", v2); err != nil {
+		t.Fatal(err)
+	}
+	if v1.Version != 1 || v2.Version != 2 {
+		t.Fatalf("expected versions 1 and 2, got %d and %d", v1.Version, v2.Version)
+	}
+	dup.Version = 2
+	if err := registry.Register("", "orders", dup); !errors.Is(err, ErrFlowExists) {
+		t.Fatalf("expected ErrFlowExists, got %v", err)
+	}
+	if err := registry.Register("", "", dup); !errors.Is(err, ErrFlowKeyRequired) {
+		t.Fatalf("expected ErrFlowKeyRequired, got %v", err)
+	}
+	if registry.Get("", "orders") != v2 {
+		t.Fatal("expected latest version to be v2")
+	}
+	if err := registry.Deprecate("", "orders", 2); err != nil {
+		t.Fatal(err)
+	}
+	if registry.Get("", "orders") != v1 {
+		t.Fatal("expected deprecated v2 to be skipped as latest")
+	}
+	if f, err := registry.GetVersion("", "orders", 2); err != nil || f != v2 {
+		t.Fatalf("expected pinned lookup of deprecated v2 to succeed: %v", err)
+	}
+	if err := registry.Retire("", "orders", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.GetVersion("", "orders", 2); !errors.Is(err, ErrFlowRetired) {
+		t.Fatalf("expected ErrFlowRetired, got %v", err)
+	}
+	if !registry.Remove(DefaultNamespace, "orders") || registry.Get("", "orders") != nil {
+		t.Fatal("expected flow to be removed")
+	}
+}
+
+func TestRegistry_PinnedExecution(t *testing.T) {
+	registry := NewRegistry()
+	version := func(name string) *Flow {
+		f := New().WithRegistry(registry)
+		f.Key = "orders"
+		f.AddNode("a", func(ctx context.Context, d Data) (Data, error) {
+			d.Payload = Payload(name)
+			return d, nil
+		})
+		if f.Build().Error != nil {
+			t.Fatal(f.Error)
+		}
+		return f
+	}
+	version("v1")
+	exec, err := registry.NewExecution("", "orders", Data{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	version("v2")
+	if exec.Version != 1 {
+		t.Fatalf("expected the execution to be pinned to version 1, got %d", exec.Version)
+	}
+	if err := registry.Run(context.Background(), exec); err != nil {
+		t.Fatal(err)
+	}
+	if string(exec.Result.Payload) != "v1" {
+		t.Fatalf("expected the pinned version to run, got %q", exec.Result.Payload)
+	}
+	f, err := registry.ForData("", exec.Input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Version != 1 {
+		t.Fatalf("expected ForData to resolve version 1, got %d", f.Version)
+	}
+	if latest := registry.Get("", "orders"); latest.Version != 2 {
+		t.Fatalf("expected latest version 2, got %d", latest.Version)
+	}
+}
@@ -0,0 +1,346 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteError Returned when a remote worker couldn't process a vertex. Errors
+// returned by the remote handler have StatusCode http.StatusUnprocessableEntity
+// and aren't retried.
+type RemoteError struct {
+	Node       string
+	StatusCode int
+	Message    string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote node '%s': %s (status %d)", e.Node, e.Message, e.StatusCode)
+}
+
+var (
+	// ErrBodyTooLarge Returned when a request body exceeds the MaxBodySize of
+	// a handler.
+	ErrBodyTooLarge = errors.New("request body too large")
+
+	// ErrResponseTooLarge Returned when the response of a worker exceeds the
+	// MaxResponseSize of a RemoteNode. It isn't retried.
+	ErrResponseTooLarge = errors.New("response too large")
+)
+
+// defaultMaxBodySize Default upper limit for the size of request bodies.
+const defaultMaxBodySize = 10 << 20
+
+// readBody Reads a request body of at most limit bytes. Returns
+// ErrBodyTooLarge if it is larger.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil && int64(len(body)) >= limit {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrBodyTooLarge, limit)
+	}
+	return body, err
+}
+
+// bodyErrorStatus Returns the status of a response to a body which couldn't
+// be read or decoded.
+func bodyErrorStatus(err error) int {
+	if errors.Is(err, ErrBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// temporary Reports whether the request may succeed if retried.
+func (e *RemoteError) temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// RemoteNode Node which processes a vertex by posting its Data to a handler
+// exposed by a WorkerServer. Add it to a flow with Flow.AddRemoteNode, or use
+// it on its own like any other node.
+type RemoteNode struct {
+	*Vertex
+
+	// Endpoint Base URL of the worker, e.g. "http://worker:8080/handlers".
+	Endpoint string
+
+	// Handler Name of the handler on the worker. Defaults to the node key.
+	Handler string
+
+	// Client Used for requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Timeout Upper limit for each attempt. Defaults to 30 seconds.
+	Timeout time.Duration
+
+	// Retries Number of times a request is retried after a network error, a
+	// timeout or a 5xx response. Defaults to 2.
+	Retries int
+
+	// Backoff Computes the delay between attempts. Defaults to an exponential
+	// backoff from 100ms.
+	Backoff Backoff
+
+	// MaxResponseSize Upper limit for the size of the worker's response.
+	// Defaults to 10 MiB.
+	MaxResponseSize int64
+}
+
+// NewRemoteNode Creates a node which runs the handler named key on the worker
+// at endpoint.
+func NewRemoteNode(key, endpoint string) *RemoteNode {
+	n := &RemoteNode{
+		Endpoint:        endpoint,
+		Handler:         key,
+		Timeout:         30 * time.Second,
+		Retries:         2,
+		MaxResponseSize: defaultMaxBodySize,
+		Backoff: ExponentialBackoff{
+			Base:       100 * time.Millisecond,
+			Multiplier: 2,
+			Cap:        5 * time.Second,
+		},
+	}
+	n.Vertex = &Vertex{
+		Key:              key,
+		Type:             "Vertex",
+		ConditionalNodes: make(map[string]string),
+		handler:          n.Call,
+		edges:            make(map[string]Node),
+		branches:         make(map[string]Node),
+	}
+	return n
+}
+
+// AddRemoteNode Adds a vertex which is processed by a remote worker.
+func (f *Flow) AddRemoteNode(node *RemoteNode) *Flow {
+	return f.AddNode(node.Key, node.Call)
+}
+
+// Call Posts data to the remote handler and returns its response, retrying
+// temporary failures. It has the signature of a Handler. Errors are returned
+// with the data, its FailedReason set to the error.
+func (n *RemoteNode) Call(ctx context.Context, data Data) (Data, error) {
+	body, err := data.MarshalBinary()
+	if err != nil {
+		return data, err
+	}
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		response, err := n.post(ctx, body)
+		if err == nil {
+			return response, nil
+		}
+		var remoteErr *RemoteError
+		temporary := !errors.Is(err, ErrResponseTooLarge)
+		if errors.As(err, &remoteErr) {
+			temporary = remoteErr.temporary()
+		}
+		if !temporary || attempt > n.Retries || ctx.Err() != nil {
+			if remoteErr != nil && remoteErr.StatusCode == http.StatusUnprocessableEntity {
+				response.FailedReason = err
+				return response, err
+			}
+			data.FailedReason = err
+			return data, err
+		}
+		if n.Backoff != nil {
+			delay = n.Backoff.Next(attempt, delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			data.FailedReason = ctx.Err()
+			return data, ctx.Err()
+		}
+	}
+}
+
+func (n *RemoteNode) post(ctx context.Context, body []byte) (Data, error) {
+	var response Data
+	if n.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.Timeout)
+		defer cancel()
+	}
+	name := n.Handler
+	if name == "" {
+		name = n.Key
+	}
+	endpoint := strings.TrimSuffix(n.Endpoint, "/") + "/" + url.PathEscape(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return response, err
+	}
+	defer res.Body.Close()
+	limit := n.MaxResponseSize
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return response, err
+	}
+	if int64(len(b)) > limit {
+		return response, fmt.Errorf("remote node '%s': %w: limit is %d bytes", n.Key, ErrResponseTooLarge, limit)
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return response, response.UnmarshalBinary(b)
+	case http.StatusUnprocessableEntity:
+		// The handler failed; its error is carried in FailedReason
+		if err := response.UnmarshalBinary(b); err != nil {
+			return response, err
+		}
+		message := "handler failed"
+		if response.FailedReason != nil {
+			message = response.FailedReason.Error()
+		}
+		return response, &RemoteError{Node: n.Key, StatusCode: res.StatusCode, Message: message}
+	default:
+		return response, &RemoteError{Node: n.Key, StatusCode: res.StatusCode, Message: strings.TrimSpace(string(b))}
+	}
+}
+
+// WorkerServer http.Handler which exposes handlers to RemoteNodes. A POST to
+// "/<name>" runs the handler added under name, or else the one registered with
+// RegisterHandler, with the Data in the request body and responds with the
+// resulting Data. If the handler fails, the response has status 422 and the
+// error in FailedReason. A GET lists the names of the exposed handlers.
+type WorkerServer struct {
+	mutex    sync.RWMutex
+	handlers map[string]Handler
+
+	// Registered Also exposes handlers registered with RegisterHandler.
+	// Defaults to true.
+	Registered bool
+
+	// MaxBodySize Upper limit for the size of request bodies. Defaults to 10 MiB.
+	MaxBodySize int64
+}
+
+// NewWorkerServer Creates a worker server.
+func NewWorkerServer() *WorkerServer {
+	return &WorkerServer{
+		handlers:    make(map[string]Handler),
+		Registered:  true,
+		MaxBodySize: defaultMaxBodySize,
+	}
+}
+
+// Handle Exposes a handler under name.
+func (s *WorkerServer) Handle(name string, handler Handler) {
+	s.mutex.Lock()
+	s.handlers[name] = handler
+	s.mutex.Unlock()
+}
+
+func (s *WorkerServer) lookup(name string) Handler {
+	s.mutex.RLock()
+	handler, ok := s.handlers[name]
+	s.mutex.RUnlock()
+	if !ok && s.Registered {
+		handler = LookupHandler(name)
+	}
+	return handler
+}
+
+// Names Returns the sorted names of the exposed handlers.
+func (s *WorkerServer) Names() []string {
+	seen := make(map[string]bool)
+	if s.Registered {
+		for _, name := range HandlerNames() {
+			seen[name] = true
+		}
+	}
+	s.mutex.RLock()
+	for name := range s.handlers {
+		seen[name] = true
+	}
+	s.mutex.RUnlock()
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *WorkerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	escaped := r.URL.EscapedPath()
+	name, err := url.PathUnescape(escaped[strings.LastIndex(escaped, "/")+1:])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Names())
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	handler := s.lookup(name)
+	if handler == nil {
+		http.Error(w, fmt.Sprintf("handler '%s' not found", name), http.StatusNotFound)
+		return
+	}
+	var data Data
+	body, err := readBody(w, r, s.MaxBodySize)
+	if err == nil {
+		err = data.UnmarshalBinary(body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorStatus(err))
+		return
+	}
+
+	status := http.StatusOK
+	response, err := func() (response Data, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+				status = http.StatusInternalServerError
+			}
+		}()
+		return handler(r.Context(), data)
+	}()
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		status = http.StatusUnprocessableEntity
+		response.FailedReason = err
+	}
+	b, err := response.MarshalBinary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,76 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteNode(t *testing.T) {
+	var calls int32
+	worker := NewWorkerServer()
+	worker.Handle("shout", func(ctx context.Context, d Data) (Data, error) {
+		d.Payload = append(d.Payload[:len(d.Payload)-1], []byte(`!"`)...)
+		return d, nil
+	})
+	worker.Handle("flaky", func(ctx context.Context, d Data) (Data, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("worker crashed")
+		}
+		return d, nil
+	})
+	worker.Handle("reject", func(ctx context.Context, d Data) (Data, error) {
+		return d, errors.New("invalid order")
+	})
+	server := httptest.NewServer(http.StripPrefix("/handlers", worker))
+	defer server.Close()
+
+	remote := func(key string) *RemoteNode {
+		n := NewRemoteNode(key, server.URL+"/handlers")
+		n.Backoff = ConstantBackoff{Interval: time.Millisecond}
+		return n
+	}
+	f := New().AddRemoteNode(remote("shout")).AddRemoteNode(remote("flaky")).Edge("shout", "flaky")
+	data, err := f.Process(context.Background(), Data{Payload: Payload(`"hello"`)})
+	if err != nil || string(data.Payload) != `"hello!"` {
+		t.Fatalf("expected the remote result, got %s (%v)", data.Payload, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected the crashed request to be retried once, got %d calls", n)
+	}
+
+	data, err = remote("reject").Call(context.Background(), Data{})
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusUnprocessableEntity || remoteErr.Message != "invalid order" {
+		t.Fatalf("expected the handler error to be mapped, got %v", err)
+	}
+	if data.FailedReason == nil {
+		t.Fatal("expected FailedReason to be set")
+	}
+	if _, err := remote("missing").Call(context.Background(), Data{}); !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	var floods int32
+	worker.Handle("flood", func(ctx context.Context, d Data) (Data, error) {
+		atomic.AddInt32(&floods, 1)
+		d.Payload = Payload(`"` + strings.Repeat("a", 1024) + `"`)
+		return d, nil
+	})
+	flood := remote("flood")
+	flood.MaxResponseSize = 512
+	if _, err := flood.Call(context.Background(), Data{}); !errors.Is(err, ErrResponseTooLarge) || atomic.LoadInt32(&floods) != 1 {
+		t.Fatalf("expected ErrResponseTooLarge without a retry, got %v after %d calls", err, floods)
+	}
+
+	worker.MaxBodySize = 64
+	large := Payload(`"` + strings.Repeat("a", 64) + `"`)
+	if _, err := remote("shout").Call(context.Background(), Data{Payload: large}); !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a too large error, got %v", err)
+	}
+}
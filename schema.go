@@ -0,0 +1,490 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaID Identifier of the JSON Schema describing RawFlow documents.
+const SchemaID = "https://github.com/sujit-baniya/flow/raw-flow.schema.json"
+
+var vertexSchema = map[string]interface{}{"type": "string", "minLength": 1}
+
+// schemaRules Keywords which can't be derived from the Go types, by
+// "Type.json_name".
+var schemaRules = map[string]map[string]interface{}{
+	"RawFlow.nodes":        {"items": vertexSchema},
+	"RawFlow.entry_points": {"items": vertexSchema},
+	"RawFlow.edges": {"items": map[string]interface{}{
+		"type":     "array",
+		"items":    vertexSchema,
+		"minItems": 2,
+		"maxItems": 2,
+	}},
+	"RawFlow.loops": {"items": map[string]interface{}{
+		"type":     "array",
+		"items":    vertexSchema,
+		"minItems": 2,
+	}},
+	"RawFlow.process_operation_count": {"minimum": 0},
+	"RawFlow.queues":                  {"additionalProperties": vertexSchema},
+	"Branch.key":                      vertexSchema,
+	"Branch.conditional_nodes":        {"minProperties": 1, "additionalProperties": vertexSchema},
+	"Condition.status":                vertexSchema,
+	"Condition.operator":              {"enum": conditionOperators},
+	"ForEach.in_vertex":               vertexSchema,
+	"ForEach.child_vertex":            {"minItems": 1, "items": vertexSchema},
+}
+
+var schemaRequired = map[string][]string{
+	"Branch":    {"key", "conditional_nodes"},
+	"Condition": {"status"},
+	"ForEach":   {"in_vertex", "child_vertex"},
+}
+
+// JSONSchema Returns a JSON Schema (draft 2020-12) describing RawFlow documents,
+// generated from the RawFlow, Branch and ForEach types. The same schema is used
+// by ValidateRaw.
+func JSONSchema() map[string]interface{} {
+	defs := make(map[string]interface{})
+	root := objectSchema(reflect.TypeOf(RawFlow{}), defs)
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = SchemaID
+	root["title"] = "RawFlow"
+	root["$defs"] = defs
+	return root
+}
+
+// JSONSchemaBytes Returns JSONSchema encoded as indented JSON.
+func JSONSchemaBytes() ([]byte, error) {
+	return json.MarshalIndent(JSONSchema(), "", "  ")
+}
+
+func objectSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		schema := typeSchema(field.Type, defs)
+		for k, v := range schemaRules[t.Name()+"."+name] {
+			schema[k] = v
+		}
+		properties[name] = schema
+	}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if required, ok := schemaRequired[t.Name()]; ok {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func typeSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil
+			defs[t.Name()] = objectSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// ValidationError Describes one problem in a RawFlow document. Path is a JSON
+// Pointer to the offending value.
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, e.Message)
+}
+
+// ValidationErrors Lists all problems found in a RawFlow document.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateRaw Checks a RawFlow document in any supported format against
+// JSONSchema and then for semantic problems, such as edges to undefined
+// vertices or cycles. Returns *ParseError if the document can't be decoded and
+// ValidationErrors if it is invalid.
+func ValidateRaw(raw []byte) error {
+	return ValidateWithFormat(DetectFormat(raw), raw)
+}
+
+// ValidateWithFormat Validates a RawFlow document like ValidateRaw, in the
+// given format.
+func ValidateWithFormat(format Format, raw []byte) error {
+	doc, err := decodeGeneric(raw, format)
+	if err != nil {
+		return err
+	}
+	schema := JSONSchema()
+	var errs ValidationErrors
+	validateSchema(schema, schema, doc, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	rawFlow, err := ParseRaw(raw, format)
+	if err != nil {
+		return err
+	}
+	return rawFlow.Validate()
+}
+
+// decodeGeneric Decodes a document into JSON-compatible values, so every format
+// is validated the same way.
+func decodeGeneric(raw []byte, format Format) (interface{}, error) {
+	var doc interface{}
+	switch format {
+	case FormatJSON:
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, jsonParseError(raw, err)
+		}
+		return doc, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(raw, &doc); err != nil {
+			return nil, yamlParseError(nil, err)
+		}
+	case FormatTOML:
+		if _, err := toml.Decode(string(raw), &doc); err != nil {
+			return nil, tomlParseError(err)
+		}
+	}
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return nil, &ParseError{Format: format, Err: err}
+	}
+	doc = nil
+	if err := json.Unmarshal(normalized, &doc); err != nil {
+		return nil, &ParseError{Format: format, Err: err}
+	}
+	return doc, nil
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaInt(schema map[string]interface{}, keyword string) (int, bool) {
+	v, ok := schema[keyword].(int)
+	return v, ok
+}
+
+// validateSchema Implements the subset of JSON Schema used by JSONSchema.
+func validateSchema(root, schema map[string]interface{}, value interface{}, path string, errs *ValidationErrors) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		validateSchema(root, root["$defs"].(map[string]interface{})[name].(map[string]interface{}), value, path, errs)
+		return
+	}
+	actual := jsonType(value)
+	if expected, ok := schema["type"].(string); ok && expected != actual {
+		if expected != "number" || actual != "integer" {
+			fail("expected %s, got %s", expected, actual)
+			return
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		if min, ok := schemaInt(schema, "minLength"); ok && len(v) < min {
+			fail("must not be empty")
+		}
+		if enum, ok := schema["enum"].([]string); ok && !containsString(enum, v) {
+			fail("must be one of %s", strings.Join(enum, ", "))
+		}
+	case float64:
+		if min, ok := schemaInt(schema, "minimum"); ok && v < float64(min) {
+			fail("must be at least %d", min)
+		}
+	case []interface{}:
+		if min, ok := schemaInt(schema, "minItems"); ok && len(v) < min {
+			fail("expected at least %d items, got %d", min, len(v))
+		}
+		if max, ok := schemaInt(schema, "maxItems"); ok && len(v) > max {
+			fail("expected at most %d items, got %d", max, len(v))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validateSchema(root, items, item, fmt.Sprintf("%s/%d", path, i), errs)
+			}
+		}
+	case map[string]interface{}:
+		if min, ok := schemaInt(schema, "minProperties"); ok && len(v) < min {
+			fail("expected at least %d properties, got %d", min, len(v))
+		}
+		if required, ok := schema["required"].([]string); ok {
+			for _, name := range required {
+				if _, ok := v[name]; !ok {
+					fail("missing required property '%s'", name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPath := path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				validateSchema(root, property, v[key], childPath, errs)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					*errs = append(*errs, &ValidationError{Path: childPath, Message: "unknown property"})
+				}
+			case map[string]interface{}:
+				validateSchema(root, additional, v[key], childPath, errs)
+			}
+		}
+	}
+}
+
+// Validate Checks the graph described by a RawFlow for semantic problems which
+// the schema can't express. Returns ValidationErrors or nil.
+func (r *RawFlow) Validate() error {
+	var errs ValidationErrors
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	vertices := make(map[string]bool)
+	for _, node := range r.Nodes {
+		vertices[node] = true
+	}
+	for _, edge := range r.Edges {
+		for _, v := range edge {
+			vertices[v] = true
+		}
+	}
+	for _, loop := range r.Loops {
+		for _, v := range loop {
+			vertices[v] = true
+		}
+	}
+	for _, forEach := range r.ForEach {
+		vertices[forEach.InVertex] = true
+		for _, v := range forEach.ChildVertex {
+			vertices[v] = true
+		}
+	}
+	for _, branch := range r.Branches {
+		vertices[branch.Key] = true
+		for _, v := range branch.ConditionalNodes {
+			vertices[v] = true
+		}
+	}
+
+	if r.FirstNode != "" && !vertices[r.FirstNode] {
+		fail("/first_node", "vertex '%s' is not defined", r.FirstNode)
+	}
+	if r.LastNode != "" && !vertices[r.LastNode] {
+		fail("/last_node", "vertex '%s' is not defined", r.LastNode)
+	}
+
+	edges := make(map[string][]string)
+	seenEdges := make(map[[2]string]bool)
+	for i, edge := range r.Edges {
+		if len(edge) != 2 {
+			fail(fmt.Sprintf("/edges/%d", i), "expected exactly 2 vertices, got %d", len(edge))
+			continue
+		}
+		key := [2]string{edge[0], edge[1]}
+		switch {
+		case edge[0] == edge[1]:
+			fail(fmt.Sprintf("/edges/%d", i), "vertex '%s' has an edge to itself", edge[0])
+		case seenEdges[key]:
+			fail(fmt.Sprintf("/edges/%d", i), "duplicate edge from '%s' to '%s'", edge[0], edge[1])
+		default:
+			edges[edge[0]] = append(edges[edge[0]], edge[1])
+		}
+		seenEdges[key] = true
+	}
+
+	for i, loop := range r.Loops {
+		if len(loop) < 2 {
+			fail(fmt.Sprintf("/loops/%d", i), "expected a loop vertex and at least one child vertex")
+			continue
+		}
+		seen := make(map[string]bool)
+		for j, v := range loop[1:] {
+			if v == loop[0] || seen[v] {
+				fail(fmt.Sprintf("/loops/%d/%d", i, j+1), "vertex '%s' is repeated in the loop", v)
+			}
+			seen[v] = true
+		}
+	}
+
+	branches := make(map[string]bool)
+	for i, branch := range r.Branches {
+		if branches[branch.Key] {
+			fail(fmt.Sprintf("/branches/%d/key", i), "vertex '%s' already has a branch", branch.Key)
+		}
+		branches[branch.Key] = true
+		if len(branch.ConditionalNodes) == 0 {
+			fail(fmt.Sprintf("/branches/%d/conditional_nodes", i), "expected at least one conditional node")
+			continue
+		}
+		for condition, v := range branch.ConditionalNodes {
+			if v == branch.Key {
+				fail(fmt.Sprintf("/branches/%d/conditional_nodes/%s", i, condition), "vertex '%s' branches to itself", v)
+			}
+		}
+		for j, condition := range branch.Conditions {
+			if _, ok := branch.ConditionalNodes[condition.Status]; !ok {
+				fail(fmt.Sprintf("/branches/%d/conditions/%d/status", i, j), "no conditional node for status '%s'", condition.Status)
+			}
+		}
+	}
+
+	queued := make([]string, 0, len(r.Queues))
+	for v := range r.Queues {
+		queued = append(queued, v)
+	}
+	sort.Strings(queued)
+	for _, v := range queued {
+		if !vertices[v] {
+			fail("/queues/"+v, "vertex '%s' is not defined", v)
+		}
+	}
+
+	seenEntries := make(map[string]bool)
+	for i, v := range r.EntryPoints {
+		switch {
+		case !vertices[v]:
+			fail(fmt.Sprintf("/entry_points/%d", i), "vertex '%s' is not defined", v)
+		case seenEntries[v]:
+			fail(fmt.Sprintf("/entry_points/%d", i), "vertex '%s' is repeated", v)
+		}
+		seenEntries[v] = true
+	}
+	if len(r.EntryPoints) > 0 && r.FirstNode != "" {
+		fail("/first_node", "first_node can't be combined with entry_points")
+	}
+
+	if cycle := findCycle(edges); cycle != nil {
+		fail("/edges", "cycle detected: %s", strings.Join(cycle, " -> "))
+	} else if len(vertices) > 0 {
+		if _, err := r.Entries(); err != nil {
+			fail("/entry_points", "%v", err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// findCycle Returns the vertices of the first cycle found in the graph, in
+// deterministic order, or nil.
+func findCycle(edges map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var stack []string
+	var visit func(v string) []string
+	visit = func(v string) []string {
+		state[v] = visiting
+		stack = append(stack, v)
+		for _, next := range edges[v] {
+			switch state[next] {
+			case visiting:
+				for i, s := range stack {
+					if s == next {
+						return append(append([]string{}, stack[i:]...), next)
+					}
+				}
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[v] = visited
+		return nil
+	}
+	vertices := make([]string, 0, len(edges))
+	for v := range edges {
+		vertices = append(vertices, v)
+	}
+	sort.Strings(vertices)
+	for _, v := range vertices {
+		if state[v] == unvisited {
+			if cycle := visit(v); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
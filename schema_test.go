@@ -0,0 +1,60 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestValidateRaw(t *testing.T) {
+	valid := []byte(`{
+		"edges": [["get-registration", "verify-user"]],
+		"branches": [{
+			"key": "verify-user",
+			"conditional_nodes": {"pass": "create-user", "fail": "cancel-registration"}
+		}]
+	}`)
+	if err := ValidateRaw(valid); err != nil {
+		t.Fatalf("expected valid document, got %v", err)
+	}
+
+	cases := map[string]string{
+		`{"edges": [["a"]]}`:                                       "/edges/0",
+		`{"edges": [["a", "b"]], "nodse": ["a"]}`:                  "/nodse",
+		`{"branches": [{"key": "a"}]}`:                             "/branches/0",
+		`{"edges": [["a", "b"], ["b", "a"]]}`:                      "/edges",
+		`{"edges": [["a", "b"]], "first_node": "c"}`:               "/first_node",
+		"edges:\n  - [a, b]\nloops:\n  - [a]\n":                    "/loops/0",
+		"process_operation_count = \"x\"\n":                        "/process_operation_count",
+		`{"for_each": [{"in_vertex": "", "child_vertex": ["a"]}]}`: "/for_each/0/in_vertex",
+		`{"branches": [{"key": "a", "conditional_nodes": {"ok": "b"}, "conditions": [{"status": "ok", "operator": "like"}]}]}`: "/branches/0/conditions/0/operator",
+		`{"branches": [{"key": "a", "conditional_nodes": {"ok": "b"}, "conditions": [{"status": "no"}]}]}`:                     "/branches/0/conditions/0/status",
+	}
+	for doc, path := range cases {
+		var errs ValidationErrors
+		if err := ValidateRaw([]byte(doc)); !errors.As(err, &errs) {
+			t.Errorf("%s: expected ValidationErrors, got %v", doc, err)
+			continue
+		}
+		if errs[0].Path != path {
+			t.Errorf("%s: expected error at %s, got %v", doc, path, errs)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	b, err := JSONSchemaBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	defs := schema["$defs"].(map[string]interface{})
+	for _, name := range []string{"Branch", "ForEach"} {
+		if _, ok := defs[name]; !ok {
+			t.Errorf("expected %s in $defs", name)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server http.Handler which exposes the flows of a registry as a REST API:
+//
+//	POST /flows/{key}/run     runs a flow and responds with its result
+//	POST /flows/{key}/start   starts a flow and responds with an execution ID
+//	GET  /executions/{id}     responds with the status and result of an execution
+//	GET  /flows               lists the flows
+//	GET  /flows/{key}         responds with the RawFlow and DOT graph of a flow
+//
+// The "namespace" query parameter selects the namespace, and "version" a flow
+// version. The request body becomes the Payload of the Data, and the
+// X-Request-ID header its RequestID.
+type Server struct {
+	// Runner Runs the flows started with /start. If it is nil, they run in a
+	// goroutine and their executions are kept in Store.
+	Runner *BackgroundRunner
+
+	// Store Keeps the executions of flows started without a Runner. Defaults to
+	// a MemoryExecutions store.
+	Store ExecutionStore
+
+	// MaxBodySize Upper limit for the size of request bodies. Defaults to 10 MiB.
+	MaxBodySize int64
+
+	registry *Registry
+}
+
+// NewServer Creates a server for the flows of a registry, which defaults to
+// DefaultRegistry.
+func NewServer(registry *Registry) *Server {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &Server{
+		Store:       NewMemoryExecutions(),
+		MaxBodySize: defaultMaxBodySize,
+		registry:    registry,
+	}
+}
+
+// FlowResult Response to a flow run.
+type FlowResult struct {
+	ExecutionID  string          `json:"execution_id,omitempty"`
+	RequestID    string          `json:"request_id,omitempty"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+	Status       string          `json:"status"`
+	FailedReason string          `json:"failed_reason,omitempty"`
+}
+
+// FlowSummary Describes a flow in the list of flows.
+type FlowSummary struct {
+	Namespace string        `json:"namespace"`
+	Key       string        `json:"key"`
+	Version   int           `json:"version"`
+	Versions  []FlowVersion `json:"versions"`
+}
+
+// FlowDetail Describes a single flow.
+type FlowDetail struct {
+	Namespace string   `json:"namespace"`
+	Key       string   `json:"key"`
+	Version   int      `json:"version"`
+	Raw       *RawFlow `json:"raw"`
+	DOT       string   `json:"dot"`
+}
+
+// payloadJSON Returns the payload as is if it is JSON, or else as a JSON
+// string.
+func payloadJSON(payload Payload) json.RawMessage {
+	if len(payload) == 0 {
+		return nil
+	}
+	if json.Valid(payload) {
+		return json.RawMessage(payload)
+	}
+	b, _ := json.Marshal(string(payload))
+	return b
+}
+
+// resultOf Describes the outcome of an execution. The status is that of the
+// resulting Data if the flow set one, or else of the execution.
+func resultOf(exec *Execution) FlowResult {
+	result := FlowResult{
+		ExecutionID: exec.ID,
+		RequestID:   exec.Input.RequestID,
+		Status:      exec.Status,
+	}
+	switch exec.Status {
+	case StatusCompleted:
+		result.Payload = payloadJSON(exec.Result.Payload)
+		if exec.Result.Status != "" {
+			result.Status = exec.Result.Status
+		}
+	case StatusFailed:
+		result.Payload = payloadJSON(exec.Result.Payload)
+		result.FailedReason = exec.Error
+		if result.FailedReason == "" && exec.Result.FailedReason != nil {
+			result.FailedReason = exec.Result.FailedReason.Error()
+		}
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrFlowNotFound), errors.Is(err, ErrExecutionNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrFlowRetired):
+		status = http.StatusGone
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// pathSegments Splits an escaped URL path into unescaped segments.
+func pathSegments(u *url.URL) ([]string, error) {
+	var segments []string
+	for _, segment := range strings.Split(strings.Trim(u.EscapedPath(), "/"), "/") {
+		segment, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments, err := pathSegments(r.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+	}
+
+	route := func(method string, n int, name string) bool {
+		if len(segments) != n || segments[0] != name {
+			return false
+		}
+		return r.Method == method
+	}
+	switch {
+	case route(http.MethodGet, 1, "flows"):
+		s.listFlows(w, namespace)
+	case route(http.MethodGet, 2, "flows"):
+		s.getFlow(w, namespace, segments[1], version)
+	case route(http.MethodPost, 3, "flows") && segments[2] == "run":
+		s.run(w, r, namespace, segments[1], version)
+	case route(http.MethodPost, 3, "flows") && segments[2] == "start":
+		s.start(w, r, namespace, segments[1], version)
+	case route(http.MethodGet, 2, "executions"):
+		s.getExecution(w, segments[1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) listFlows(w http.ResponseWriter, namespace string) {
+	summaries := []FlowSummary{}
+	for _, key := range s.registry.List(namespace) {
+		summary := FlowSummary{
+			Namespace: namespaceOf(namespace),
+			Key:       key,
+			Versions:  s.registry.Versions(namespace, key),
+		}
+		if f := s.registry.Get(namespace, key); f != nil {
+			summary.Version = f.Version
+		}
+		summaries = append(summaries, summary)
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *Server) getFlow(w http.ResponseWriter, namespace, key string, version int) {
+	f, err := s.registry.GetVersion(namespace, key, version)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	raw := f.Raw()
+	writeJSON(w, http.StatusOK, FlowDetail{
+		Namespace: namespaceOf(namespace),
+		Key:       key,
+		Version:   f.Version,
+		Raw:       raw,
+		DOT:       raw.DOT(),
+	})
+}
+
+// requestData Maps a request to the Data a flow is run with.
+func (s *Server) requestData(w http.ResponseWriter, r *http.Request) (Data, error) {
+	body, err := readBody(w, r, s.MaxBodySize)
+	if err != nil {
+		return Data{}, err
+	}
+	return Data{
+		RequestID: r.Header.Get("X-Request-ID"),
+		Payload:   body,
+	}, nil
+}
+
+func (s *Server) run(w http.ResponseWriter, r *http.Request, namespace, key string, version int) {
+	data, err := s.requestData(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorStatus(err))
+		return
+	}
+	exec, err := s.registry.newExecution(namespace, key, version, data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if err := s.registry.Run(r.Context(), exec); err != nil {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, resultOf(exec))
+}
+
+func (s *Server) start(w http.ResponseWriter, r *http.Request, namespace, key string, version int) {
+	data, err := s.requestData(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorStatus(err))
+		return
+	}
+	var exec *Execution
+	if s.Runner != nil {
+		exec, err = s.Runner.start(namespace, key, version, data)
+	} else {
+		exec, err = startLocal(s.registry, s.Store, namespace, key, version, data)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, resultOf(exec))
+}
+
+// startLocal Runs a flow in a goroutine, keeping its execution in a store.
+func startLocal(registry *Registry, store ExecutionStore, namespace, key string, version int, data Data) (*Execution, error) {
+	exec, err := registry.newExecution(namespace, key, version, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.SaveExecution(exec); err != nil {
+		return nil, err
+	}
+	run := *exec
+	go func() {
+		_ = registry.Run(context.Background(), &run)
+		if err := store.SaveExecution(&run); err != nil {
+			log.Printf("Failed to save execution %s: %v", run.ID, err)
+		}
+	}()
+	return exec, nil
+}
+
+// ExecutionResult Response describing an execution.
+type ExecutionResult struct {
+	FlowResult
+	Namespace  string    `json:"namespace"`
+	Flow       string    `json:"flow"`
+	Version    int       `json:"version"`
+	Attempts   int       `json:"attempts"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func (s *Server) getExecution(w http.ResponseWriter, id string) {
+	store := s.Store
+	if s.Runner != nil {
+		store = s.Runner.Store
+	}
+	exec, err := store.GetExecution(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ExecutionResult{
+		FlowResult: resultOf(exec),
+		Namespace:  exec.Namespace,
+		Flow:       exec.Flow,
+		Version:    exec.Version,
+		Attempts:   exec.Attempts,
+		StartedAt:  exec.StartedAt,
+		FinishedAt: exec.FinishedAt,
+	})
+}
@@ -0,0 +1,115 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	registry := NewRegistry()
+	f := New().WithRegistry(registry)
+	f.Key = "greet"
+	f.AddNode("hello", func(ctx context.Context, d Data) (Data, error) {
+		d.Payload = Payload(`{"greeting":"hello ` + string(d.Payload) + `"}`)
+		return d, nil
+	}).AddNode("log", echo).Edge("hello", "log")
+	if f.Build().Error != nil {
+		t.Fatal(f.Error)
+	}
+	server := httptest.NewServer(NewServer(registry))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/flows/greet/run", "text/plain", strings.NewReader("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result FlowResult
+	_ = json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || result.Status != StatusCompleted || string(result.Payload) != `{"greeting":"hello world"}` {
+		t.Fatalf("unexpected run result %d %+v", res.StatusCode, result)
+	}
+
+	res, err = http.Post(server.URL+"/flows/greet/start", "text/plain", strings.NewReader("async"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted || result.ExecutionID == "" {
+		t.Fatalf("unexpected start result %d %+v", res.StatusCode, result)
+	}
+	var execution ExecutionResult
+	for deadline := time.Now().Add(5 * time.Second); execution.Status != StatusCompleted; {
+		if time.Now().After(deadline) {
+			t.Fatalf("execution did not complete: %+v", execution)
+		}
+		res, err := http.Get(server.URL + "/executions/" + result.ExecutionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = json.NewDecoder(res.Body).Decode(&execution)
+		res.Body.Close()
+	}
+	if execution.Flow != "greet" || string(execution.Payload) != `{"greeting":"hello async"}` {
+		t.Fatalf("unexpected execution %+v", execution)
+	}
+
+	res, err = http.Get(server.URL + "/flows/greet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var detail FlowDetail
+	_ = json.NewDecoder(res.Body).Decode(&detail)
+	res.Body.Close()
+	if detail.Version != 1 || !strings.Contains(detail.DOT, `"hello" -> "log";`) {
+		t.Fatalf("unexpected flow detail %+v", detail)
+	}
+
+	res, err = http.Post(server.URL+"/flows/missing/run", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing flow, got %d", res.StatusCode)
+	}
+}
+
+func TestServer_MaxBodySize(t *testing.T) {
+	registry := NewRegistry()
+	f := New().WithRegistry(registry)
+	f.Key = "echo"
+	f.AddNode("echo", echo)
+	if f.Build().Error != nil {
+		t.Fatal(f.Error)
+	}
+	handler := NewServer(registry)
+	handler.MaxBodySize = 8
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, path := range []string{"/flows/echo/run", "/flows/echo/start"} {
+		res, err := http.Post(server.URL+path, "text/plain", strings.NewReader("more than eight bytes"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected 413 for a large body, got %d", path, res.StatusCode)
+		}
+	}
+	res, err := http.Post(server.URL+"/flows/echo/run", "text/plain", strings.NewReader("small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for a small body, got %d", res.StatusCode)
+	}
+}
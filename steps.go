@@ -0,0 +1,439 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// StepTaskType The task type of vertices enqueued by a StepRunner.
+const StepTaskType = "flow.step"
+
+// ErrQueueNotFound Returned when a flow routes a vertex to a queue which wasn't
+// added to the StepRunner.
+var ErrQueueNotFound = errors.New("queue not found")
+
+// stepRun Payload of a step task. It processes a vertex or, with Join set, one
+// element of a loop vertex. Stack holds the vertices still to be processed
+// afterwards, innermost last, each receiving the output of the one before.
+// Branch marks the target of a branch, whose final failure continues with the
+// edges of the branch vertex like Vertex.Process does.
+type stepRun struct {
+	Execution string          `json:"execution"`
+	Namespace string          `json:"namespace"`
+	Flow      string          `json:"flow"`
+	Version   int             `json:"version"`
+	Vertex    string          `json:"vertex"`
+	Join      string          `json:"join,omitempty"`
+	Element   int             `json:"element,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Data      []byte          `json:"data"`
+	Stack     [][]string      `json:"stack"`
+	Branch    bool            `json:"branch,omitempty"`
+}
+
+// stepJoin Collects the results of the elements of a loop vertex.
+type stepJoin struct {
+	run       stepRun
+	response  Data
+	results   []interface{}
+	remaining int
+}
+
+// StepRunner Runs flows with each vertex as its own task, so every step is
+// retried and persisted on its own. Completing a vertex enqueues the matched
+// branch and its edges with its output, and each element of a loop vertex is
+// processed by its own task. Vertices are routed to the queues named in the
+// flow's RawFlow, see Flow.OnQueue, which lets heavy vertices run on queues
+// with their own workers.
+//
+// The results of loop elements are collected in memory, so a run interrupted
+// by a restart while inside a loop isn't resumed.
+type StepRunner struct {
+	// Store Keeps the executions. Defaults to a MemoryExecutions store.
+	Store ExecutionStore
+
+	// Retries The maximum number of attempts of each step. Defaults to 3.
+	Retries int
+
+	// Configure Optionally adjusts each task before it is enqueued.
+	Configure func(task *Task)
+
+	// OnComplete Is called once a run completes or a step finally fails, with
+	// the final execution.
+	OnComplete func(exec *Execution, err error)
+
+	mutex    sync.Mutex
+	registry *Registry
+	queue    *Queue
+	queues   map[string]*Queue
+	joins    map[string]*stepJoin
+}
+
+// NewStepRunner Creates a runner for the flows of a registry. Vertices which
+// aren't routed to a named queue run on the given queue. The registry
+// defaults to DefaultRegistry.
+func NewStepRunner(queue *Queue, registry *Registry) *StepRunner {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	r := &StepRunner{
+		Store:    NewMemoryExecutions(),
+		Retries:  3,
+		registry: registry,
+		queue:    queue,
+		queues:   make(map[string]*Queue),
+		joins:    make(map[string]*stepJoin),
+	}
+	r.AddQueue(queue.name, queue)
+	return r
+}
+
+// AddQueue Adds a queue vertices can be routed to by name, and registers the
+// StepTaskType task type on it.
+func (r *StepRunner) AddQueue(name string, queue *Queue) {
+	queue.RegisterTaskType(StepTaskType, r.run)
+	queue.AfterTaskType(StepTaskType, r.after)
+	r.mutex.Lock()
+	r.queues[name] = queue
+	r.mutex.Unlock()
+}
+
+func (r *StepRunner) queueOf(f *Flow, vertex string) (*Queue, error) {
+	name, ok := f.raw.Queues[vertex]
+	if !ok {
+		return r.queue, nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if queue, ok := r.queues[name]; ok {
+		return queue, nil
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrQueueNotFound, name)
+}
+
+// Start Enqueues the first vertex of the latest active version of a flow and
+// returns its execution. The RequestID of the input defaults to the execution
+// ID.
+func (r *StepRunner) Start(namespace, key string, data Data) (*Execution, error) {
+	exec, err := r.registry.NewExecution(namespace, key, data)
+	if err != nil {
+		return nil, err
+	}
+	f, err := r.registry.GetVersion(namespace, key, exec.Version)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.entries) != 1 {
+		return nil, fmt.Errorf("flow '%s' has %d entry points; steps can only run flows with one", key, len(f.entries))
+	}
+	first := f.entries[0]
+	for vertex := range f.raw.Queues {
+		if _, err := r.queueOf(f, vertex); err != nil {
+			return nil, err
+		}
+	}
+	start := []string{first.GetKey()}
+	if f.lastNode != nil {
+		start = append(start, f.lastNode.GetKey())
+	}
+
+	if exec.Input.RequestID == "" {
+		exec.Input.RequestID = exec.ID
+	}
+	exec.Status = StatusProcessing
+	exec.Attempts = 1
+	exec.StartedAt = Now()
+	if err := r.Store.SaveExecution(exec); err != nil {
+		return nil, err
+	}
+	run := stepRun{
+		Execution: exec.ID,
+		Namespace: exec.Namespace,
+		Flow:      exec.Flow,
+		Version:   exec.Version,
+		Stack:     [][]string{start},
+	}
+	if err := r.advance(f, run, exec.Input); err != nil {
+		exec.Status = StatusFailed
+		exec.Error = err.Error()
+		_ = r.Store.SaveExecution(exec)
+		return nil, err
+	}
+	return exec, nil
+}
+
+// Get Returns an execution started by this runner.
+func (r *StepRunner) Get(id string) (*Execution, error) {
+	return r.Store.GetExecution(id)
+}
+
+func (r *StepRunner) enqueue(f *Flow, run stepRun) error {
+	queue, err := r.queueOf(f, run.Vertex)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	task := NewTypedTask(StepTaskType, payload).Retries(r.Retries)
+	task.Metadata["flow"] = run.Flow
+	task.Metadata["vertex"] = run.Vertex
+	task.Metadata["execution"] = run.Execution
+	if r.Configure != nil {
+		r.Configure(task)
+	}
+	return queue.Enqueue(task)
+}
+
+// advance Enqueues the next vertex on the stack with data, or completes the
+// execution if there is none.
+func (r *StepRunner) advance(f *Flow, run stepRun, data Data) error {
+	stack := run.Stack
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if len(top) == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		return r.step(f, run, top[0], append(stack[:len(stack)-1:len(stack)-1], top[1:]), data, false)
+	}
+	r.finish(run.Execution, data, nil)
+	return nil
+}
+
+// step Enqueues a vertex with data, followed by the vertices of stack.
+func (r *StepRunner) step(f *Flow, run stepRun, vertex string, stack [][]string, data Data, branch bool) error {
+	b, err := data.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	next := run
+	next.Vertex, next.Join, next.Element, next.Value = vertex, "", 0, nil
+	next.Data, next.Stack, next.Branch = b, stack, branch
+	return r.enqueue(f, next)
+}
+
+// next Pushes the edges of a processed vertex onto the stack and enqueues its
+// matched branch, or else advances with its response.
+func (r *StepRunner) next(f *Flow, v *Vertex, run stepRun, response Data) error {
+	status, err := v.branchStatus(response)
+	if err != nil {
+		return err
+	}
+	edges := make([]string, 0, len(v.edges))
+	for key := range v.edges {
+		edges = append(edges, key)
+	}
+	sort.Strings(edges)
+	run.Stack = append(run.Stack[:len(run.Stack):len(run.Stack)], edges)
+	if n, ok := v.branches[status]; ok {
+		return r.step(f, run, n.GetKey(), run.Stack, response, true)
+	}
+	return r.advance(f, run, response)
+}
+
+// run Processes a step task.
+func (r *StepRunner) run(ctx context.Context, payload []byte) error {
+	var run stepRun
+	if err := json.Unmarshal(payload, &run); err != nil {
+		return err
+	}
+	var data Data
+	if err := data.UnmarshalBinary(run.Data); err != nil {
+		return err
+	}
+	f, err := r.registry.GetVersion(run.Namespace, run.Flow, run.Version)
+	if err != nil {
+		return err
+	}
+	n, ok := f.nodes[run.Vertex]
+	if !ok {
+		return fmt.Errorf("vertex '%s' is not defined", run.Vertex)
+	}
+	v, ok := n.(*Vertex)
+	if !ok {
+		// Nodes which aren't vertices, such as nested flows, are opaque steps
+		// which process their own successors, as in a Plan
+		response, err := n.Process(ctx, data)
+		if err != nil {
+			return err
+		}
+		return r.advance(f, run, response)
+	}
+	if run.Join != "" {
+		return r.element(ctx, f, v, run, data)
+	}
+
+	if v.GetType() == "Branch" && len(v.ConditionalNodes) == 0 {
+		return errors.New("required at least one condition for branch")
+	}
+	response, err := v.handler(ctx, data)
+	if err != nil {
+		return err
+	}
+	if v.Type == "Loop" {
+		var rs []interface{}
+		if err := json.Unmarshal(response.Payload, &rs); err != nil {
+			return err
+		}
+		if len(rs) > 0 {
+			return r.fork(f, run, data, response, rs)
+		}
+		response.Payload = Payload("null")
+	}
+	return r.next(f, v, run, response)
+}
+
+// fork Enqueues a task for every element of a loop vertex's response.
+func (r *StepRunner) fork(f *Flow, run stepRun, data, response Data, rs []interface{}) error {
+	id := newID()
+	r.mutex.Lock()
+	r.joins[id] = &stepJoin{
+		run:       run,
+		response:  response,
+		results:   make([]interface{}, len(rs)),
+		remaining: len(rs),
+	}
+	r.mutex.Unlock()
+	b, err := data.MarshalBinary()
+	if err == nil {
+		for i, single := range rs {
+			element := run
+			element.Join, element.Element, element.Data = id, i, b
+			if element.Value, err = json.Marshal(single); err != nil {
+				break
+			}
+			if err = r.enqueue(f, element); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		// Elements which were enqueued find no join and are dropped
+		r.mutex.Lock()
+		delete(r.joins, id)
+		r.mutex.Unlock()
+	}
+	return err
+}
+
+// element Processes one element of a loop vertex, and once every element is
+// done advances with their results.
+func (r *StepRunner) element(ctx context.Context, f *Flow, v *Vertex, run stepRun, data Data) error {
+	var single interface{}
+	if err := json.Unmarshal(run.Value, &single); err != nil {
+		return err
+	}
+	result, err := processElement(ctx, nodeList(v.loops), data, single)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	join, ok := r.joins[run.Join]
+	if !ok {
+		r.mutex.Unlock()
+		log.Printf("Dropping element %d of loop '%s' for execution %s: the loop is no longer running", run.Element, run.Vertex, run.Execution)
+		return nil
+	}
+	join.results[run.Element] = result
+	join.remaining--
+	done := join.remaining == 0
+	if done {
+		delete(r.joins, run.Join)
+	}
+	r.mutex.Unlock()
+	if !done {
+		return nil
+	}
+
+	response := join.response
+	if response.Payload, err = json.Marshal(join.results); err != nil {
+		return err
+	}
+	if err := r.next(f, v, join.run, response); err != nil {
+		// Let the retried element complete the join again
+		r.mutex.Lock()
+		join.remaining++
+		r.joins[run.Join] = join
+		r.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// after Fails the execution of a step which finally failed. A failed branch
+// target is recorded in FailedReason instead, and the edges of its branch
+// vertex are processed if it has any.
+func (r *StepRunner) after(ctx context.Context, task *Task) {
+	err := finalError(task)
+	if err == nil {
+		return
+	}
+	var run stepRun
+	if json.Unmarshal(task.Payload(), &run) != nil {
+		return
+	}
+	if run.Join != "" {
+		r.mutex.Lock()
+		delete(r.joins, run.Join)
+		r.mutex.Unlock()
+	}
+	if run.Branch && len(run.Stack) > 0 && len(run.Stack[len(run.Stack)-1]) > 0 {
+		if err = r.skipBranch(run, task.LastError()); err == nil {
+			return
+		}
+	}
+	r.finish(run.Execution, Data{}, fmt.Errorf("vertex '%s': %w", run.Vertex, err))
+}
+
+// skipBranch Advances with the input of a failed branch target, its error in
+// FailedReason.
+func (r *StepRunner) skipBranch(run stepRun, reason error) error {
+	f, err := r.registry.GetVersion(run.Namespace, run.Flow, run.Version)
+	if err != nil {
+		return err
+	}
+	var data Data
+	if err := data.UnmarshalBinary(run.Data); err != nil {
+		return err
+	}
+	data.FailedReason = reason
+	return r.advance(f, run, data)
+}
+
+// finish Records the outcome of an execution once, and reports it.
+func (r *StepRunner) finish(id string, result Data, err error) {
+	r.mutex.Lock()
+	exec, getErr := r.Store.GetExecution(id)
+	if getErr != nil {
+		r.mutex.Unlock()
+		log.Printf("Failed to load execution %s: %v", id, getErr)
+		return
+	}
+	if exec.Status != StatusProcessing {
+		r.mutex.Unlock()
+		return
+	}
+	exec.FinishedAt = Now()
+	if err != nil {
+		exec.Status = StatusFailed
+		exec.Error = err.Error()
+	} else {
+		exec.Status = StatusCompleted
+		exec.Result = result
+	}
+	if err := r.Store.SaveExecution(exec); err != nil {
+		log.Printf("Failed to save execution %s: %v", exec.ID, err)
+	}
+	r.mutex.Unlock()
+	if r.OnComplete != nil {
+		r.OnComplete(exec, err)
+	}
+}
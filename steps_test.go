@@ -0,0 +1,174 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStepRunner(t *testing.T) {
+	registry := NewRegistry()
+	var heavyCalls int32
+	f := New().WithRegistry(registry)
+	f.Key = "orders"
+	f.AddNode("prepare", func(ctx context.Context, d Data) (Data, error) {
+		d.Payload = Payload(`[{"id":1},{"id":2},{"id":3}]`)
+		return d, nil
+	}).AddNode("split", echo).AddNode("enrich", func(ctx context.Context, d Data) (Data, error) {
+		d.Payload = Payload(`{"enriched":true}`)
+		return d, nil
+	}).AddNode("heavy", func(ctx context.Context, d Data) (Data, error) {
+		if atomic.AddInt32(&heavyCalls, 1) == 1 {
+			return d, errors.New("out of memory")
+		}
+		return d, nil
+	}).Edge("prepare", "split").Loop("split", "enrich").Edge("split", "heavy").OnQueue("heavy", "heavy")
+	if f.Build().Error != nil {
+		t.Fatal(f.Error)
+	}
+
+	later := func() time.Time {
+		return time.Now().UTC().Add(time.Hour)
+	}
+	main, heavy := NewQueue("main"), NewQueue("heavy")
+	main.Now(later)
+	heavy.Now(later)
+	runner := NewStepRunner(main, registry)
+	runner.AddQueue("heavy", heavy)
+	done := make(chan *Execution, 1)
+	runner.OnComplete = func(exec *Execution, err error) {
+		done <- exec
+	}
+	if _, err := runner.Start("", "orders", Data{}); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := main.Tasks(); len(tasks) != 1 || tasks[0].Metadata["vertex"] != "prepare" {
+		t.Fatalf("expected the first vertex to be enqueued, got %+v", tasks)
+	}
+	dispatch := func(q *Queue) bool {
+		busy := q.Dispatch(context.Background())
+		q.inflight.Wait()
+		return busy
+	}
+	for dispatch(main) || dispatch(heavy) {
+	}
+
+	exec := <-done
+	if exec.Status != StatusCompleted || atomic.LoadInt32(&heavyCalls) != 2 {
+		t.Fatalf("expected the run to complete after retrying the heavy vertex, got %+v", exec)
+	}
+	var result []map[string]interface{}
+	if err := json.Unmarshal(exec.Result.Payload, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 || result[2]["id"] != 3.0 || result[2]["enriched"] != true {
+		t.Fatalf("expected the enriched loop elements in order, got %s", exec.Result.Payload)
+	}
+}
+
+func TestStepRunner_FailedBranch(t *testing.T) {
+	for name, notify := range map[string]bool{"with edges": true, "without edges": false} {
+		registry := NewRegistry()
+		f := New().WithRegistry(registry)
+		f.Key = "refunds"
+		f.AddNode("check", func(ctx context.Context, d Data) (Data, error) {
+			d.Status = "reject"
+			d.Payload = Payload(`"checked"`)
+			return d, nil
+		}).AddNode("reject", func(ctx context.Context, d Data) (Data, error) {
+			return d, errors.New("mail server down")
+		}).ConditionalNode("check", map[string]string{"reject": "reject"})
+		if notify {
+			f.AddNode("notify", func(ctx context.Context, d Data) (Data, error) {
+				d.Payload = Payload(`"notified: ` + d.FailedReason.Error() + `"`)
+				d.FailedReason = nil
+				return d, nil
+			}).Edge("check", "notify")
+		}
+		if f.Build().Error != nil {
+			t.Fatal(f.Error)
+		}
+		expected, processErr := f.Process(context.Background(), Data{})
+		if notify && (processErr != nil || string(expected.Payload) != `"notified: mail server down"`) {
+			t.Fatalf("%s: expected Process to continue after the failed branch, got %s (%v)", name, expected.Payload, processErr)
+		}
+
+		q := NewQueue("refunds")
+		q.Now(func() time.Time {
+			return time.Now().UTC().Add(time.Hour)
+		})
+		runner := NewStepRunner(q, registry)
+		runner.Retries = 1
+		done := make(chan *Execution, 1)
+		runner.OnComplete = func(exec *Execution, err error) {
+			done <- exec
+		}
+		if _, err := runner.Start("", "refunds", Data{}); err != nil {
+			t.Fatal(err)
+		}
+		for q.Dispatch(context.Background()) {
+			q.inflight.Wait()
+		}
+		exec := <-done
+		if processErr != nil {
+			if exec.Status != StatusFailed || !strings.HasPrefix(exec.Error, "vertex 'reject'") {
+				t.Errorf("%s: expected the execution to fail like Process (%v), got %+v", name, processErr, exec)
+			}
+			continue
+		}
+		if exec.Status != StatusCompleted || string(exec.Result.Payload) != string(expected.Payload) || exec.Result.FailedReason != nil {
+			t.Errorf("%s: expected %s like Process, got %+v", name, expected.Payload, exec)
+		}
+	}
+}
+
+func TestStepRunner_NestedFlow(t *testing.T) {
+	appendTo := func(s string) Handler {
+		return func(ctx context.Context, d Data) (Data, error) {
+			d.Payload = Payload(string(d.Payload) + s)
+			return d, nil
+		}
+	}
+	sub := New().WithRegistry(NewRegistry()).
+		AddNode("x", appendTo("x")).
+		AddNode("y", appendTo("y")).
+		Edge("x", "y").
+		Build()
+	if sub.Error != nil {
+		t.Fatal(sub.Error)
+	}
+	sub.Key = "sub"
+
+	registry := NewRegistry()
+	f := New().WithRegistry(registry).AddNode("a", appendTo("a")).AddNode("b", appendTo("b"))
+	f.Key = "nested"
+	f.AddEdge(sub)
+	f.Edge("a", "sub").Edge("a", "b")
+	if f.Build().Error != nil {
+		t.Fatal(f.Error)
+	}
+
+	q := NewQueue("nested")
+	runner := NewStepRunner(q, registry)
+	done := make(chan *Execution, 1)
+	runner.OnComplete = func(exec *Execution, err error) {
+		done <- exec
+	}
+	if _, err := runner.Start("", "nested", Data{Payload: Payload(">")}); err != nil {
+		t.Fatal(err)
+	}
+	for q.Dispatch(context.Background()) {
+		q.inflight.Wait()
+	}
+	exec := <-done
+	if exec.Status != StatusCompleted || string(exec.Result.Payload) != ">abxy" {
+		t.Fatalf("expected the nested flow to run as a step, got %+v", exec)
+	}
+	if result, err := f.Process(context.Background(), Data{Payload: Payload(">")}); err != nil || string(result.Payload) != ">abxy" {
+		t.Fatalf("expected Process to agree, got %s (%v)", result.Payload, err)
+	}
+}
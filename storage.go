@@ -0,0 +1,207 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownTaskType Returned when a typed task refers to a task type which
+// hasn't been registered.
+var ErrUnknownTaskType = errors.New("unknown task type")
+
+// TaskRecord Serializable state of a typed task, as kept by a QueueStore.
+type TaskRecord struct {
+	ID            string                 `json:"id"`
+	Type          string                 `json:"type"`
+	UniqueKey     string                 `json:"unique_key,omitempty"`
+	Payload       []byte                 `json:"payload"`
+	Metadata      map[string]interface{} `json:"metadata"`
+	Priority      int                    `json:"priority"`
+	Attempts      int                    `json:"attempts"`
+	MaxAttempts   int                    `json:"max_attempts"`
+	NextAttempt   time.Time              `json:"next_attempt"`
+	BaseDuration  time.Duration          `json:"base_duration"`
+	SleepDuration time.Duration          `json:"sleep_duration"`
+	MaxTimeout    time.Duration          `json:"max_timeout"`
+	Within        time.Duration          `json:"within"`
+	Jitter        bool                   `json:"jitter"`
+	Backoff       *BackoffRecord         `json:"backoff,omitempty"`
+	History       []AttemptRecord        `json:"history,omitempty"`
+}
+
+// QueueStore Persists the pending tasks of queues, so they survive a restart.
+// Only typed tasks (see NewTypedTask) are stored, since functions can't be
+// serialized.
+type QueueStore interface {
+	// Save Creates or replaces a task record.
+	Save(queue string, record TaskRecord) error
+
+	// Delete Removes a task record. Deleting a missing record is not an error.
+	Delete(queue string, id string) error
+
+	// Load Returns all task records of a queue.
+	Load(queue string) ([]TaskRecord, error)
+}
+
+func (t *Task) record() TaskRecord {
+	return TaskRecord{
+		ID:            t.id,
+		Type:          t.typeName,
+		UniqueKey:     t.unique,
+		Payload:       t.payload,
+		Metadata:      t.Metadata,
+		Priority:      t.priority,
+		Attempts:      t.attempts,
+		MaxAttempts:   t.maxAttempts,
+		NextAttempt:   t.nextAttempt,
+		BaseDuration:  t.baseDuration,
+		SleepDuration: t.sleepDuration,
+		MaxTimeout:    t.maxTimeout,
+		Within:        t.within,
+		Jitter:        t.jitter,
+		Backoff:       backoffRecord(t.backoff),
+		History:       t.history,
+	}
+}
+
+// taskFromRecord Restores a typed task. Returns an error if its backoff
+// strategy can't be restored.
+func taskFromRecord(record TaskRecord) (*Task, error) {
+	t := NewTypedTask(record.Type, record.Payload)
+	t.id = record.ID
+	t.unique = record.UniqueKey
+	if record.Metadata != nil {
+		t.Metadata = record.Metadata
+	}
+	t.priority = record.Priority
+	t.attempts = record.Attempts
+	t.maxAttempts = record.MaxAttempts
+	t.nextAttempt = record.NextAttempt
+	t.baseDuration = record.BaseDuration
+	t.sleepDuration = record.SleepDuration
+	t.maxTimeout = record.MaxTimeout
+	t.within = record.Within
+	t.jitter = record.Jitter
+	t.history = record.History
+	if record.Backoff != nil {
+		b, err := record.Backoff.Strategy()
+		if err != nil {
+			return nil, fmt.Errorf("task '%s': %w", record.ID, err)
+		}
+		t.backoff = b
+	}
+	return t, nil
+}
+
+// FileStore QueueStore which keeps one JSON file per task in a directory per
+// queue. Files are replaced atomically, so a crash never leaves a partially
+// written record behind.
+type FileStore struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+// NewFileStore Creates a file store rooted at dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(parts ...string) string {
+	escaped := make([]string, 0, len(parts)+1)
+	escaped = append(escaped, s.dir)
+	for _, part := range parts {
+		escaped = append(escaped, strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(part))
+	}
+	return filepath.Join(escaped...)
+}
+
+func (s *FileStore) write(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readAll Decodes every JSON file of a directory, in file name order, by
+// calling decode with its content.
+func (s *FileStore) readAll(dir string, decode func(b []byte) error) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := decode(b); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Save Implements QueueStore.
+func (s *FileStore) Save(queue string, record TaskRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(s.path(queue, "tasks", record.ID+".json"), record)
+}
+
+// Delete Implements QueueStore.
+func (s *FileStore) Delete(queue string, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.remove(s.path(queue, "tasks", id+".json"))
+}
+
+// Load Implements QueueStore.
+func (s *FileStore) Load(queue string) ([]TaskRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var records []TaskRecord
+	err := s.readAll(s.path(queue, "tasks"), func(b []byte) error {
+		var record TaskRecord
+		if err := json.Unmarshal(b, &record); err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
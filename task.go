@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -20,6 +20,9 @@ var (
 	// ErrMaxRetriesExceeded This task has been attempted too many times.
 	ErrMaxRetriesExceeded = errors.New("the maximum retries for this task has been exceeded")
 
+	// ErrTaskCancelled The task was cancelled through its queue.
+	ErrTaskCancelled = errors.New("this task was cancelled")
+
 	// Now Set this function to influence the clock that will be used for
 	// scheduling re-attempts.
 	Now = func() time.Time {
@@ -29,18 +32,65 @@ var (
 
 type TaskFunc func(ctx context.Context) error
 
+// AttemptRecord Describes one attempt of a task.
+type AttemptRecord struct {
+	Attempt   int           `json:"attempt"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// TaskTypeFunc Executes a task of a registered type with the task's payload.
+type TaskTypeFunc func(ctx context.Context, payload []byte) error
+
+var taskTypes = struct {
+	sync.RWMutex
+	m map[string]TaskTypeFunc
+}{m: make(map[string]TaskTypeFunc)}
+
+// RegisterTaskType Registers a task type by name. Tasks created with
+// NewTypedTask refer to their function by this name, which lets them be stored
+// and reloaded by a persistent queue.
+func RegisterTaskType(name string, fn TaskTypeFunc) {
+	taskTypes.Lock()
+	taskTypes.m[name] = fn
+	taskTypes.Unlock()
+}
+
+// LookupTaskType Returns the task type registered under name, or nil.
+func LookupTaskType(name string) TaskTypeFunc {
+	taskTypes.RLock()
+	defer taskTypes.RUnlock()
+	return taskTypes.m[name]
+}
+
 // Task Stores state for a task which shall be or has been executed. Each task may
 // only be executed successfully once.
 type Task struct {
 	Metadata map[string]interface{}
 
+	unique      string
+	id          string
+	cancel      context.CancelFunc
+	cancelled   bool
+	typeName    string
+	payload     []byte
 	after       func(ctx context.Context, task *Task)
 	attempts    int
 	done        bool
 	err         error
 	fn          TaskFunc
 	nextAttempt time.Time
+	history     []AttemptRecord
+	running     bool
+	snapshot    *TaskInfo
+	priority    int
+	enqueuedAt  time.Time
+	seq         uint64
+	heapIndex   int
+	readyKey    float64
 
+	backoff       Backoff
 	baseDuration  time.Duration
 	immutable     bool
 	jitter        bool
@@ -57,6 +107,8 @@ func NewTask(fn TaskFunc) *Task {
 
 		baseDuration:  time.Minute,
 		fn:            fn,
+		id:            newID(),
+		heapIndex:     -1,
 		jitter:        true,
 		maxAttempts:   1,
 		maxTimeout:    30 * time.Minute,
@@ -64,6 +116,68 @@ func NewTask(fn TaskFunc) *Task {
 	}
 }
 
+// NewTypedTask Creates a new task which runs the task type registered under
+// name with the given payload. The function is resolved when the task is
+// enqueued, first from the queue's own types and then from RegisterTaskType.
+func NewTypedTask(name string, payload []byte) *Task {
+	t := NewTask(nil)
+	t.typeName = name
+	t.payload = payload
+	return t
+}
+
+// ID Returns the ID of the task, which is generated when the task is created
+// unless set with WithID.
+func (t *Task) ID() string {
+	return t.id
+}
+
+// WithID Sets a caller-supplied ID for the task. IDs must be unique among the
+// tasks of a queue.
+func (t *Task) WithID(id string) *Task {
+	if id == "" {
+		panic(errors.New("invalid input to Task.WithID"))
+	}
+	if t.immutable {
+		panic(errors.New("attempted to configure immutable task"))
+	}
+	t.id = id
+	return t
+}
+
+// Unique Sets a uniqueness key for the task. While a task with the same key is
+// pending or running, or completed within the queue's unique window, enqueueing
+// it again is handled as a duplicate; see Queue.OnDuplicate.
+func (t *Task) Unique(key string) *Task {
+	if t.immutable {
+		panic(errors.New("attempted to configure immutable task"))
+	}
+	t.unique = key
+	return t
+}
+
+// UniqueKey Returns the uniqueness key of the task, if any.
+func (t *Task) UniqueKey() string {
+	return t.unique
+}
+
+// Type Returns the registered type name of the task, or an empty string for
+// tasks created from a function.
+func (t *Task) Type() string {
+	return t.typeName
+}
+
+// Payload Returns the payload of a typed task.
+func (t *Task) Payload() []byte {
+	return t.payload
+}
+
+func (t *Task) bind(fn TaskTypeFunc) {
+	t.fn = func(ctx context.Context) error {
+		return fn(ctx, t.payload)
+	}
+}
+
 // Attempt to execute this task.
 //
 // If successful, the zero time and nil are returned.
@@ -81,12 +195,7 @@ func (t *Task) Attempt(ctx context.Context) (time.Time, error) {
 
 	t.attempts += 1
 	if t.attempts > t.maxAttempts {
-		t.err = ErrMaxRetriesExceeded
-		t.done = true
-		if t.after != nil {
-			t.after(ctx, t)
-			t.after = nil
-		}
+		t.finish(ctx, ErrMaxRetriesExceeded)
 		return time.Time{}, ErrMaxRetriesExceeded
 	}
 
@@ -100,6 +209,7 @@ func (t *Task) Attempt(ctx context.Context) (time.Time, error) {
 		defer cancel()
 	}
 
+	started := Now()
 	func() {
 		defer func() {
 			if err := recover(); err != nil {
@@ -108,20 +218,26 @@ func (t *Task) Attempt(ctx context.Context) (time.Time, error) {
 		}()
 		t.err = t.fn(ctx)
 	}()
+	record := AttemptRecord{
+		Attempt:   t.attempts,
+		StartedAt: started,
+		Duration:  Now().Sub(started),
+	}
+	if t.err != nil {
+		record.Error = t.err.Error()
+	}
+	t.history = append(t.history, record)
 
 	if t.err == nil {
-		t.done = true
-		if t.after != nil {
-			t.after(ctx, t)
-			t.after = nil
-		}
+		t.finish(ctx, nil)
 		return time.Time{}, nil
 	}
 
-	if t.jitter {
-		rand.Seed(time.Now().UnixNano())
+	if t.backoff != nil {
+		t.sleepDuration = t.backoff.Next(t.attempts, t.sleepDuration)
+	} else if t.jitter {
 		max := int((t.sleepDuration*3 - t.baseDuration + time.Minute).Minutes())
-		sleep := time.Duration(rand.Intn(max))*time.Minute + t.baseDuration
+		sleep := time.Duration(randomIntn(max))*time.Minute + t.baseDuration
 		if t.sleepDuration = sleep; t.sleepDuration > t.maxTimeout {
 			t.sleepDuration = t.maxTimeout
 		}
@@ -141,6 +257,17 @@ func (t *Task) Attempt(ctx context.Context) (time.Time, error) {
 	return t.nextAttempt, t.err
 }
 
+// finish Marks the task as done with its final result and runs its 'After'
+// function.
+func (t *Task) finish(ctx context.Context, err error) {
+	t.err = err
+	t.done = true
+	if t.after != nil {
+		t.after(ctx, t)
+		t.after = nil
+	}
+}
+
 // Retries Set the maximum number of retries on failure, or -1 to attempt indefinitely.
 // By default, tasks are not retried on failure.
 func (t *Task) Retries(n int) *Task {
@@ -181,6 +308,17 @@ func (t *Task) After(fn func(ctx context.Context, task *Task)) *Task {
 	return t
 }
 
+// Priority Sets the priority of the task. When more tasks are due than a queue
+// has free workers, higher priorities are attempted first. Defaults to zero;
+// negative priorities are allowed.
+func (t *Task) Priority(priority int) *Task {
+	if t.immutable {
+		panic(errors.New("attempted to configure immutable task"))
+	}
+	t.priority = priority
+	return t
+}
+
 // Within Specifies an upper limit for the duration of each attempt.
 func (t *Task) Within(deadline time.Duration) *Task {
 	if t.immutable {
@@ -199,6 +337,22 @@ func (t *Task) NotBefore(date time.Time) *Task {
 	return t
 }
 
+// Backoff Sets the strategy computing the delay between attempts, replacing
+// the default backoff in whole minutes. MaxTimeout and NoJitter don't apply to
+// custom strategies. Only the strategies of this package, or pointers to them,
+// can be persisted by a QueueStore; enqueueing a typed task with another
+// strategy on a queue with a store returns ErrBackoffNotPersistable.
+func (t *Task) Backoff(b Backoff) *Task {
+	if t.immutable {
+		panic(errors.New("attempted to configure immutable task"))
+	}
+	t.backoff = b
+	if b != nil {
+		t.sleepDuration = 0
+	}
+	return t
+}
+
 // NoJitter Specifies that randomness should not be introduced into the exponential
 // backoff algorithm.
 func (t *Task) NoJitter() *Task {
@@ -220,6 +374,41 @@ func (t *Task) Attempts() int {
 	return t.attempts
 }
 
+// History Returns a record of every attempt of this task which ran its
+// function.
+func (t *Task) History() []AttemptRecord {
+	return t.history
+}
+
+// LastError Returns the error of the last attempt which ran the task function,
+// which is preserved after the task fails with ErrMaxRetriesExceeded.
+func (t *Task) LastError() error {
+	if n := len(t.history); n > 0 {
+		if last := t.history[n-1].Error; last != "" {
+			return errors.New(last)
+		}
+		return nil
+	}
+	return t.err
+}
+
+// reset Clears the outcome of a task so it can be attempted again with a fresh
+// retry budget.
+func (t *Task) reset() {
+	t.attempts = 0
+	t.done = false
+	t.err = nil
+	t.history = nil
+	t.nextAttempt = time.Time{}
+	t.sleepDuration = t.baseDuration
+	if t.backoff != nil {
+		t.sleepDuration = 0
+	}
+	t.enqueuedAt = time.Time{}
+	t.seq = 0
+	t.heapIndex = -1
+}
+
 // NextAttempt Returns the time the next attempt is scheduled for, or the zero value if it
 // has not been attempted before.
 func (t *Task) NextAttempt() time.Time {
@@ -0,0 +1,243 @@
+package flow
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrInvalidSignature Returned when a webhook request isn't signed with the
+	// trigger's secret.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+
+	// ErrInvalidTrigger Returned when adding a trigger which is misconfigured.
+	ErrInvalidTrigger = errors.New("invalid trigger")
+)
+
+var signatureHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Trigger Starts a flow when a webhook is posted to its route.
+type Trigger struct {
+	// Route Path of the webhook, e.g. "/github".
+	Route string
+
+	// Namespace and Flow Select the flow which is started.
+	Namespace string
+	Flow      string
+
+	// Secret Key the request body is signed with. Requests are not verified
+	// if it is empty.
+	Secret []byte
+
+	// SignatureHeader Header carrying the signature, e.g. "X-Hub-Signature-256".
+	SignatureHeader string
+
+	// SignaturePrefix Prefix of the signature in the header, e.g. "sha256=".
+	SignaturePrefix string
+
+	// Algorithm Hash of the HMAC: "sha1", "sha256" or "sha512". Defaults to
+	// "sha256".
+	Algorithm string
+
+	// Base64 Specifies that the signature is base64 rather than hex encoded.
+	Base64 bool
+
+	// IdempotencyHeader Header whose value becomes the RequestID of the Data,
+	// e.g. "X-GitHub-Delivery".
+	IdempotencyHeader string
+
+	// OperationHeader and OperationQuery Header or query parameter whose value
+	// becomes the Operation of the Data, e.g. "X-GitHub-Event". Operation is
+	// used if neither is present.
+	OperationHeader string
+	OperationQuery  string
+	Operation       string
+
+	// Map Optionally adjusts the Data after the request has been mapped to it.
+	Map func(r *http.Request, data *Data) error
+
+	// Async Responds with an execution ID as soon as the flow is started,
+	// instead of waiting for its result.
+	Async bool
+}
+
+// verify Checks the signature of a request body.
+func (t *Trigger) verify(r *http.Request, body []byte) error {
+	if len(t.Secret) == 0 {
+		return nil
+	}
+	signature := r.Header.Get(t.SignatureHeader)
+	if !strings.HasPrefix(signature, t.SignaturePrefix) {
+		return ErrInvalidSignature
+	}
+	signature = strings.TrimPrefix(signature, t.SignaturePrefix)
+	var received []byte
+	var err error
+	if t.Base64 {
+		received, err = base64.StdEncoding.DecodeString(signature)
+	} else {
+		received, err = hex.DecodeString(signature)
+	}
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(signatureHashes[t.Algorithm], t.Secret)
+	mac.Write(body)
+	if !hmac.Equal(received, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// data Maps a request to the Data the flow is started with.
+func (t *Trigger) data(r *http.Request, body []byte) (Data, error) {
+	data := Data{
+		Payload:   body,
+		RequestID: r.Header.Get(t.IdempotencyHeader),
+		Operation: t.Operation,
+	}
+	if op := r.Header.Get(t.OperationHeader); op != "" {
+		data.Operation = op
+	} else if op := r.URL.Query().Get(t.OperationQuery); t.OperationQuery != "" && op != "" {
+		data.Operation = op
+	}
+	if t.Map != nil {
+		if err := t.Map(r, &data); err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// Triggers http.Handler which starts flows from webhooks. Each trigger is
+// served at its route, accepts POST requests and responds like the run and
+// start endpoints of Server.
+type Triggers struct {
+	// Runner Runs the flows of async triggers. If it is nil, they run in a
+	// goroutine and their executions are kept in Store.
+	Runner *BackgroundRunner
+
+	// Store Keeps the executions of flows started without a Runner. Defaults to
+	// a MemoryExecutions store.
+	Store ExecutionStore
+
+	// MaxBodySize Upper limit for the size of request bodies. Defaults to 10 MiB.
+	MaxBodySize int64
+
+	mutex    sync.RWMutex
+	registry *Registry
+	triggers map[string]*Trigger
+}
+
+// NewTriggers Creates a handler for webhooks starting the flows of a registry,
+// which defaults to DefaultRegistry.
+func NewTriggers(registry *Registry) *Triggers {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &Triggers{
+		Store:       NewMemoryExecutions(),
+		MaxBodySize: defaultMaxBodySize,
+		registry:    registry,
+		triggers:    make(map[string]*Trigger),
+	}
+}
+
+// Add Adds a trigger, replacing any trigger with the same route.
+func (t *Triggers) Add(trigger Trigger) error {
+	if trigger.Flow == "" {
+		return fmt.Errorf("%w: flow key is required", ErrInvalidTrigger)
+	}
+	if trigger.Algorithm == "" {
+		trigger.Algorithm = "sha256"
+	}
+	if _, ok := signatureHashes[trigger.Algorithm]; !ok {
+		return fmt.Errorf("%w: unknown algorithm '%s'", ErrInvalidTrigger, trigger.Algorithm)
+	}
+	if len(trigger.Secret) > 0 && trigger.SignatureHeader == "" {
+		return fmt.Errorf("%w: signature header is required with a secret", ErrInvalidTrigger)
+	}
+	trigger.Route = "/" + strings.Trim(trigger.Route, "/")
+	t.mutex.Lock()
+	t.triggers[trigger.Route] = &trigger
+	t.mutex.Unlock()
+	return nil
+}
+
+// Remove Removes the trigger of a route.
+func (t *Triggers) Remove(route string) bool {
+	route = "/" + strings.Trim(route, "/")
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, ok := t.triggers[route]
+	delete(t.triggers, route)
+	return ok
+}
+
+func (t *Triggers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mutex.RLock()
+	trigger, ok := t.triggers["/"+strings.Trim(r.URL.Path, "/")]
+	t.mutex.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := readBody(w, r, t.MaxBodySize)
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorStatus(err))
+		return
+	}
+	if err := trigger.verify(r, body); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	data, err := trigger.data(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if trigger.Async {
+		var exec *Execution
+		if t.Runner != nil {
+			exec, err = t.Runner.start(trigger.Namespace, trigger.Flow, 0, data)
+		} else {
+			exec, err = startLocal(t.registry, t.Store, trigger.Namespace, trigger.Flow, 0, data)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, resultOf(exec))
+		return
+	}
+	exec, err := t.registry.NewExecution(trigger.Namespace, trigger.Flow, data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if err := t.registry.Run(r.Context(), exec); err != nil {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, resultOf(exec))
+}
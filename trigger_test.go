@@ -0,0 +1,128 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTriggers(t *testing.T) {
+	registry := NewRegistry()
+	var received Data
+	f := New().WithRegistry(registry)
+	f.Key = "on-push"
+	f.AddNode("record", func(ctx context.Context, d Data) (Data, error) {
+		received = d
+		return d, nil
+	})
+	if f.Build().Error != nil {
+		t.Fatal(f.Error)
+	}
+	triggers := NewTriggers(registry)
+	err := triggers.Add(Trigger{
+		Route:             "/github",
+		Flow:              "on-push",
+		Secret:            []byte("s3cret"),
+		SignatureHeader:   "X-Hub-Signature-256",
+		SignaturePrefix:   "sha256=",
+		IdempotencyHeader: "X-GitHub-Delivery",
+		OperationHeader:   "X-GitHub-Event",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(triggers)
+	defer server.Close()
+
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	post := func(signature string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/github", bytes.NewReader(body))
+		req.Header.Set("X-Hub-Signature-256", signature)
+		req.Header.Set("X-GitHub-Delivery", "delivery-1")
+		req.Header.Set("X-GitHub-Event", "push")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := post("sha256=" + hex.EncodeToString([]byte("forged")))
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a forged signature to be rejected, got %d", res.StatusCode)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	res = post("sha256=" + hex.EncodeToString(mac.Sum(nil)))
+	var result FlowResult
+	_ = json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || result.Status != StatusCompleted || result.RequestID != "delivery-1" {
+		t.Fatalf("unexpected result %d %+v", res.StatusCode, result)
+	}
+	if received.Operation != "push" || string(received.Payload) != string(body) {
+		t.Fatalf("expected the request to be mapped to Data, got %+v", received)
+	}
+}
+
+func TestTriggers_Async(t *testing.T) {
+	registry := NewRegistry()
+	f := New().WithRegistry(registry)
+	f.Key = "on-order"
+	f.AddNode("record", echo)
+	if f.Build().Error != nil {
+		t.Fatal(f.Error)
+	}
+	triggers := NewTriggers(registry)
+	triggers.MaxBodySize = 64
+	if err := triggers.Add(Trigger{Route: "/orders", Flow: "on-order", Async: true}); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(triggers)
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/orders", "application/json", strings.NewReader(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result FlowResult
+	_ = json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted || result.ExecutionID == "" {
+		t.Fatalf("expected the flow to be started, got %d %+v", res.StatusCode, result)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		exec, err := triggers.Store.GetExecution(result.ExecutionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exec.Status == StatusCompleted {
+			if string(exec.Result.Payload) != `{"id":1}` {
+				t.Fatalf("unexpected result %s", exec.Result.Payload)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("execution did not complete: %+v", exec)
+		}
+	}
+
+	res, err = http.Post(server.URL+"/orders", "application/json", strings.NewReader(strings.Repeat(" ", 65)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for a large body, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,113 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDuplicateTask Returned by Enqueue when a task with the same uniqueness key
+// is pending, running or completed within the unique window, and the queue
+// rejects duplicates.
+var ErrDuplicateTask = errors.New("a task with this unique key is already queued")
+
+// DuplicatePolicy Decides how a queue handles duplicate unique tasks.
+type DuplicatePolicy int
+
+const (
+	// DuplicateReject Enqueue returns ErrDuplicateTask for duplicates.
+	DuplicateReject DuplicatePolicy = iota
+
+	// DuplicateMerge Enqueue drops duplicates without an error. Metadata keys
+	// the pending task doesn't have yet are copied to it.
+	DuplicateMerge
+)
+
+type uniqueEntry struct {
+	id        string
+	completed time.Time
+}
+
+// UniqueWindow Sets how long the uniqueness key of a completed task is
+// remembered, so duplicates arriving shortly after it finished are caught too.
+// Defaults to zero, which only deduplicates against pending and running tasks.
+// Completed keys are kept in memory and don't survive a restart.
+func (q *Queue) UniqueWindow(d time.Duration) {
+	if d < 0 {
+		panic(errors.New("invalid input to Queue.UniqueWindow"))
+	}
+	q.mutex.Lock()
+	q.uniqueWindow = d
+	q.mutex.Unlock()
+}
+
+// OnDuplicate Sets how duplicate unique tasks are handled. Defaults to
+// DuplicateReject.
+func (q *Queue) OnDuplicate(policy DuplicatePolicy) {
+	q.mutex.Lock()
+	q.duplicates = policy
+	q.mutex.Unlock()
+}
+
+// deduplicate Returns true if t duplicates a known unique task, applying the
+// duplicate policy, and otherwise claims its key. Must be called with the
+// mutex held.
+func (q *Queue) deduplicate(t *Task) (bool, error) {
+	if t.unique == "" {
+		return false, nil
+	}
+	q.pruneUnique()
+	entry, ok := q.uniques[t.unique]
+	if !ok || entry.id == t.id {
+		q.uniques[t.unique] = &uniqueEntry{id: t.id}
+		return false, nil
+	}
+	if q.duplicates == DuplicateReject {
+		return true, fmt.Errorf("%w: '%s'", ErrDuplicateTask, t.unique)
+	}
+	if existing, ok := q.tasks[entry.id]; ok && !existing.running {
+		if existing.Metadata == nil {
+			existing.Metadata = make(map[string]interface{}, len(t.Metadata))
+		}
+		for k, v := range t.Metadata {
+			if _, ok := existing.Metadata[k]; !ok {
+				existing.Metadata[k] = v
+			}
+		}
+		return true, q.persist(existing)
+	}
+	return true, nil
+}
+
+// releaseUnique Remembers the key of a finished task for the unique window, or
+// forgets it. Must be called with the mutex held.
+func (q *Queue) releaseUnique(t *Task) {
+	entry, ok := q.uniques[t.unique]
+	if t.unique == "" || !ok || entry.id != t.id {
+		return
+	}
+	if q.uniqueWindow == 0 {
+		delete(q.uniques, t.unique)
+		return
+	}
+	entry.completed = q.now()
+	q.completedKeys = append(q.completedKeys, t.unique)
+}
+
+// pruneUnique Forgets completed keys whose window has passed. Keys are released
+// in completion order, so only expired keys at the front are visited. Must be
+// called with the mutex held.
+func (q *Queue) pruneUnique() {
+	now := q.now()
+	for len(q.completedKeys) > 0 {
+		key := q.completedKeys[0]
+		entry, ok := q.uniques[key]
+		if ok && !entry.completed.IsZero() && now.Sub(entry.completed) < q.uniqueWindow {
+			return
+		}
+		if ok && !entry.completed.IsZero() {
+			delete(q.uniques, key)
+		}
+		q.completedKeys = q.completedKeys[1:]
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"reflect"
 
 	"golang.org/x/sync/errgroup"
@@ -17,6 +18,7 @@ type Vertex struct {
 	edges            map[string]Node
 	branches         map[string]Node
 	loops            map[string]Node
+	conditions       []Condition
 }
 
 func merge(map1 map[string]interface{}, map2 map[string]interface{}) map[string]interface{} {
@@ -28,7 +30,18 @@ func merge(map1 map[string]interface{}, map2 map[string]interface{}) map[string]
 	return map1
 }
 
-func (v *Vertex) loop(ctx context.Context, loops map[string]Node, data Data, response Data) ([]interface{}, error) {
+// nodeList Returns the nodes of a map in no particular order.
+func nodeList(nodes map[string]Node) []Node {
+	list := make([]Node, 0, len(nodes))
+	for _, n := range nodes {
+		list = append(list, n)
+	}
+	return list
+}
+
+// processLoop Runs the children of a loop vertex in parallel for each element
+// of its response, which must be a JSON array.
+func processLoop(ctx context.Context, loops []Node, data Data, response Data) ([]interface{}, error) {
 	g, ctx := errgroup.WithContext(ctx)
 	result := make(chan interface{})
 	var rs, results []interface{}
@@ -39,44 +52,7 @@ func (v *Vertex) loop(ctx context.Context, loops map[string]Node, data Data, res
 	for _, single := range rs {
 		single := single
 		g.Go(func() error {
-			var payload []byte
-			currentData := make(map[string]interface{})
-			switch s := single.(type) {
-			case map[string]interface{}:
-				currentData = s
-			}
-			if currentData != nil {
-				payload, err = json.Marshal(currentData)
-				if err != nil {
-					return err
-				}
-			} else {
-				payload, err = json.Marshal(single)
-				if err != nil {
-					return err
-				}
-			}
-			dataPayload := data
-			dataPayload.Payload = payload
-			var responseData map[string]interface{}
-			for _, loop := range loops {
-				resp, err := loop.Process(ctx, dataPayload)
-				resp.FailedReason = err
-				if err != nil {
-					return err
-				}
-				err = json.Unmarshal(resp.Payload, &responseData)
-				if err != nil {
-					return err
-				}
-				currentData = merge(currentData, responseData)
-			}
-			payload, err = json.Marshal(currentData)
-			if err != nil {
-				return err
-			}
-			dataPayload.Payload = payload
-			err = json.Unmarshal(dataPayload.Payload, &single)
+			single, err := processElement(ctx, loops, data, single)
 			if err != nil {
 				return err
 			}
@@ -101,6 +77,54 @@ func (v *Vertex) loop(ctx context.Context, loops map[string]Node, data Data, res
 	return results, nil
 }
 
+// processElement Runs the child vertices of a loop for one element of its
+// input and returns the element merged with their results.
+func processElement(ctx context.Context, loops []Node, data Data, single interface{}) (interface{}, error) {
+	var err error
+	var payload []byte
+	currentData := make(map[string]interface{})
+	switch s := single.(type) {
+	case map[string]interface{}:
+		currentData = s
+	}
+	if currentData != nil {
+		payload, err = json.Marshal(currentData)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		payload, err = json.Marshal(single)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dataPayload := data
+	dataPayload.Payload = payload
+	var responseData map[string]interface{}
+	for _, loop := range loops {
+		resp, err := loop.Process(ctx, dataPayload)
+		resp.FailedReason = err
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(resp.Payload, &responseData)
+		if err != nil {
+			return nil, err
+		}
+		currentData = merge(currentData, responseData)
+	}
+	payload, err = json.Marshal(currentData)
+	if err != nil {
+		return nil, err
+	}
+	dataPayload.Payload = payload
+	err = json.Unmarshal(dataPayload.Payload, &single)
+	if err != nil {
+		return nil, err
+	}
+	return single, nil
+}
+
 func (v *Vertex) Process(ctx context.Context, data Data) (Data, error) {
 	if v.GetType() == "Branch" && len(v.ConditionalNodes) == 0 {
 		return data, errors.New("required at least one condition for branch")
@@ -110,7 +134,7 @@ func (v *Vertex) Process(ctx context.Context, data Data) (Data, error) {
 		return data, err
 	}
 	if v.Type == "Loop" {
-		result, err := v.loop(ctx, v.loops, data, response)
+		result, err := processLoop(ctx, nodeList(v.loops), data, response)
 		if err != nil {
 			return data, err
 		}
@@ -120,7 +144,11 @@ func (v *Vertex) Process(ctx context.Context, data Data) (Data, error) {
 		}
 		response.Payload = tmp
 	}
-	if val, ok := v.branches[response.GetStatus()]; ok {
+	status, err := v.branchStatus(response)
+	if err != nil {
+		return data, err
+	}
+	if val, ok := v.branches[status]; ok {
 		response, err = val.Process(ctx, response)
 		response.FailedReason = err
 	}
@@ -135,6 +163,23 @@ func (v *Vertex) Process(ctx context.Context, data Data) (Data, error) {
 	return response, err
 }
 
+// branchStatus Returns the status selecting the branch taken after a response:
+// the status set by the handler, or else that of the first matching condition.
+func (v *Vertex) branchStatus(response Data) (string, error) {
+	return branchStatus(v.Key, v.conditions, response)
+}
+
+func branchStatus(key string, conditions []Condition, response Data) (string, error) {
+	if status := response.GetStatus(); status != "" || len(conditions) == 0 {
+		return status, nil
+	}
+	status, err := evaluateConditions(conditions, response.Payload)
+	if err != nil {
+		return "", fmt.Errorf("branch '%s': %w", key, err)
+	}
+	return status, nil
+}
+
 func (v *Vertex) GetType() string {
 	return v.Type
 }